@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+)
+
+// openAppendFile opens the file at filePath to append lines to it,
+// creating it if it does not exist.
+//
+// The end of the file left by a run that did not finish is repaired
+// first, so that the lines appended to it can be read. A partial last
+// line is removed, and if gzipped is true, a truncated gzip stream is
+// compressed again up to its last whole line.
+func openAppendFile(filePath string, gzipped bool) (*os.File, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	if gzipped {
+		err = repairGzipLines(file)
+	} else {
+		err = truncatePartialLine(file)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// truncatePartialLine truncates the file after its last newline.
+func truncatePartialLine(file *os.File) error {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	end := fileInfo.Size()
+	buffer := make([]byte, 4096)
+	for end > 0 {
+		n := min(int64(len(buffer)), end)
+		if _, err := file.ReadAt(buffer[:n], end-n); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buffer[:n], '\n'); i >= 0 {
+			end += int64(i) + 1 - n
+			break
+		}
+		end -= n
+	}
+	if end == fileInfo.Size() {
+		return nil
+	}
+	return file.Truncate(end)
+}
+
+// repairGzipLines compresses the file again up to its last whole line
+// if its gzip stream is truncated.
+func repairGzipLines(file *os.File) error {
+	fileInfo, err := file.Stat()
+	if err != nil || fileInfo.Size() == 0 {
+		return err
+	}
+	var data []byte
+	gzipReader, err := gzip.NewReader(io.NewSectionReader(file, 0, fileInfo.Size()))
+	if err == nil {
+		data, err = io.ReadAll(gzipReader)
+	}
+	if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	gzipWriter := gzip.NewWriter(file)
+	if _, err := gzipWriter.Write(data); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
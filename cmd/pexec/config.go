@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+
+	yaml "github.com/goccy/go-yaml"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+var (
+	errConfigNil           = errors.New("config is nil")
+	errConfigCommandsEmpty = errors.New("config commands is empty")
+	errConfigStdinMultiple = errors.New("config command can only have one of stdin, stdin_file and stdin_split")
+)
+
+type config struct {
+	Dir      string     `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Commands []*command `json:"commands,omitempty" yaml:"commands,omitempty"`
+	Secrets  *secrets   `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+}
+
+// secrets are the secrets redacted from the events and output.
+//
+// The literal values are never encoded to JSON so that they are not
+// logged with the config.
+type secrets struct {
+	Values   []string `json:"-" yaml:"values,omitempty"`
+	Patterns []string `json:"patterns,omitempty" yaml:"patterns,omitempty"`
+	Env      []string `json:"env,omitempty" yaml:"env,omitempty"`
+}
+
+// command is a command in the config.
+//
+// A command is either a command line string, or a mapping with the
+// command line and its options.
+type command struct {
+	Name    string `json:"name,omitempty" yaml:"name,omitempty"`
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Stdin is the literal stdin of the command.
+	Stdin string `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+	// StdinFile is the file to read the stdin of the command from,
+	// relative to the config directory.
+	StdinFile string `json:"stdin_file,omitempty" yaml:"stdin_file,omitempty"`
+	// StdinSplit says that the command gets a share of the stdin of
+	// pexec, split round-robin across all such commands.
+	StdinSplit bool `json:"stdin_split,omitempty" yaml:"stdin_split,omitempty"`
+	// PTY says that the command runs under a pseudo-terminal, with its
+	// stdout and stderr merged.
+	PTY bool `json:"pty,omitempty" yaml:"pty,omitempty"`
+}
+
+// UnmarshalYAML unmarshals the command from either a string or a mapping.
+func (c *command) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var line string
+	if err := unmarshal(&line); err == nil {
+		*c = command{Command: line}
+		return nil
+	}
+	type rawCommand command
+	return unmarshal((*rawCommand)(c))
+}
+
+func readConfig(configFilePath string) (*config, error) {
+	data, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	if config.Dir == "" {
+		config.Dir = filepath.Dir(configFilePath)
+	} else if !filepath.IsAbs(config.Dir) {
+		config.Dir = filepath.Join(filepath.Dir(configFilePath), config.Dir)
+	}
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func validateConfig(config *config) error {
+	if config == nil {
+		return errConfigNil
+	}
+
+	if len(config.Commands) == 0 {
+		return errConfigCommandsEmpty
+	}
+
+	for _, command := range config.Commands {
+		numStdins := 0
+		for _, set := range []bool{command.Stdin != "", command.StdinFile != "", command.StdinSplit} {
+			if set {
+				numStdins++
+			}
+		}
+		if numStdins > 1 {
+			return errConfigStdinMultiple
+		}
+	}
+
+	if config.Secrets != nil {
+		for _, pattern := range config.Secrets.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("invalid secrets pattern: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getRedactor returns the Redactor for the secrets of the config, or
+// nil if there are none.
+func getRedactor(config *config) pexec.Redactor {
+	if config.Secrets == nil {
+		return nil
+	}
+	patterns := make([]*regexp.Regexp, 0, len(config.Secrets.Patterns))
+	for _, pattern := range config.Secrets.Patterns {
+		// already validated by validateConfig
+		patterns = append(patterns, regexp.MustCompile(pattern))
+	}
+	return pexec.NewRedactor(
+		pexec.WithRedactValues(config.Secrets.Values...),
+		pexec.WithRedactPatterns(patterns...),
+		pexec.WithRedactEnv(config.Secrets.Env...),
+	)
+}
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFilePath := filepath.Join(dir, "config.yaml")
+	data := `commands:
+  - echo foo
+  - name: bar
+    command: echo bar
+    stdin: baz
+    pty: true
+`
+	if err := os.WriteFile(configFilePath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := readConfig(configFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*command{
+		{Command: "echo foo"},
+		{Name: "bar", Command: "echo bar", Stdin: "baz", PTY: true},
+	}
+	if diff := cmp.Diff(want, config.Commands); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if config.Dir != dir {
+		t.Fatalf("except dir %q but got %q", dir, config.Dir)
+	}
+}
+
+func TestReadConfigStdinMultiple(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	data := `commands:
+  - command: cat
+    stdin: foo
+    stdin_split: true
+`
+	if err := os.WriteFile(configFilePath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readConfig(configFilePath); err != errConfigStdinMultiple {
+		t.Fatalf("except %v but got %v", errConfigStdinMultiple, err)
+	}
+}
@@ -0,0 +1,35 @@
+module github.com/zchee/go-pexec/cmd/pexec
+
+go 1.23
+
+require (
+	github.com/goccy/go-json v0.11.2
+	github.com/goccy/go-yaml v1.8.9
+	github.com/google/go-cmp v0.7.0
+	github.com/mattn/go-shellwords v1.0.11
+	github.com/prometheus/client_golang v1.22.0
+	github.com/zchee/go-pexec v0.0.0-00010101000000-000000000000
+	github.com/zchee/go-pexec/metrics v0.0.0-00010101000000-000000000000
+	golang.org/x/sys v0.30.0
+	golang.org/x/term v0.29.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/creack/pty v1.1.24 // indirect
+	github.com/fatih/color v1.10.0 // indirect
+	github.com/mattn/go-colorable v0.1.8 // indirect
+	github.com/mattn/go-isatty v0.0.12 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.62.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	google.golang.org/protobuf v1.36.5 // indirect
+)
+
+replace (
+	github.com/zchee/go-pexec => ../..
+	github.com/zchee/go-pexec/metrics => ../../metrics
+)
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	exec "golang.org/x/sys/execabs"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+// cmdInput sets up the stdin of the commands.
+type cmdInput struct {
+	Dir       string
+	Files     []*os.File
+	SplitCmds []pexec.Cmd
+}
+
+func newCmdInput(dir string) *cmdInput {
+	return &cmdInput{Dir: dir}
+}
+
+// Set sets the stdin of the command as configured.
+//
+// The stdin of the commands with stdin_split is only set by the
+// RunnerOption of Split, and pexecCmd is the Cmd of the command.
+func (i *cmdInput) Set(command *command, cmd *exec.Cmd, pexecCmd pexec.Cmd) error {
+	switch {
+	case command.Stdin != "":
+		cmd.Stdin = strings.NewReader(command.Stdin)
+	case command.StdinFile != "":
+		filePath := command.StdinFile
+		if !filepath.IsAbs(filePath) {
+			filePath = filepath.Join(i.Dir, filePath)
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		i.Files = append(i.Files, file)
+		cmd.Stdin = file
+	case command.StdinSplit:
+		i.SplitCmds = append(i.SplitCmds, pexecCmd)
+	}
+	return nil
+}
+
+// Split returns the RunnerOption that splits the stdin of pexec across
+// the commands with stdin_split, or nil if there are none.
+func (i *cmdInput) Split(reader io.Reader) pexec.RunnerOption {
+	if len(i.SplitCmds) == 0 {
+		return nil
+	}
+	var options []pexec.FanOutOption
+	if *flagPipeBlockSize > 0 {
+		options = append(options, pexec.WithFanOutBlocks(*flagPipeBlockSize))
+	}
+	if *flagRetries > 0 {
+		options = append(options, pexec.WithFanOutRetries())
+	}
+	return pexec.FanOutStdin(reader, i.SplitCmds, options...)
+}
+
+// Close closes the stdin files.
+func (i *cmdInput) Close() error {
+	var errs []error
+	for _, file := range i.Files {
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -5,21 +5,19 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"log/slog"
 	"os"
-	"path/filepath"
 	"runtime"
 
 	json "github.com/goccy/go-json"
-	yaml "github.com/goccy/go-yaml"
 	"github.com/mattn/go-shellwords"
 	exec "golang.org/x/sys/execabs"
 
 	pexec "github.com/zchee/go-pexec"
+	"github.com/zchee/go-pexec/metrics"
 )
 
 var (
@@ -27,17 +25,33 @@ var (
 	flagFastFail          = flag.Bool("fast-fail", false, "Fail on the first command failure")
 	flagMaxConcurrentCmds = flag.Int("max-concurrent-cmds", runtime.NumCPU(), "Maximum number of processes to run concurrently, or unlimited if 0")
 	flagNoLog             = flag.Bool("no-log", false, "Do not output logs")
+	flagLogFormat         = flag.String("log-format", "event", "The format of the logs, one of event, text or json")
+	flagLogLevel          = flag.String("log-level", "info", "The minimum level of the text or json logs, one of debug, info, warn or error")
+	flagTAP               = flag.Bool("tap", false, "Output the results in the Test Anything Protocol to stdout instead of logs, with the output of the commands on stderr")
+	flagProgress          = flag.Bool("progress", false, "Show the progress of the commands instead of logs if stdout is a terminal, with their output tagged above it")
+	flagTag               = flag.Bool("tag", false, "Prefix each line of output with the name or index of the command")
+	flagColor             = flag.Bool("color", false, "Colour the --tag prefixes")
+	flagTimestamp         = flag.Bool("timestamp", false, "Prefix each line of --tag output with the time")
+	flagGroup             = flag.Bool("group", false, "Buffer the output of each command and print it as one block when the command finishes")
+	flagKeepOrder         = flag.Bool("keep-order", false, "Print the --group output of the commands in the order they are listed")
+	flagFailureOutput     = flag.Int("failure-output", 0, "Include the last N bytes of the stdout and stderr of failed commands in their events and --junit report")
+	flagResults           = flag.String("results", "", "Write the stdout, stderr, exit code, command line and timing of each command to its own directory in the directory")
+	flagPipeBlockSize     = flag.Int("pipe-block-size", 0, "Split the stdin across the stdin_split commands in blocks of about N bytes ending at a newline rather than in lines")
+	flagSummary           = flag.String("summary", "", "Print a summary of the run to stdout when it is done, either text or json")
+	flagSummarySlowest    = flag.Int("summary-slowest", pexec.DefaultSummarySlowest, "Number of slowest commands in the --summary")
+	flagSummaryLines      = flag.Int("summary-lines", pexec.DefaultSummaryOutputLines, "Number of lines of output of each failed command in the --summary")
+	flagRetries           = flag.Int("retries", 0, "Number of times to retry each failed command")
+	flagEventsFile        = flag.String("events-file", "", "Write events as JSON Lines to the file, gzipped if it ends in .gz")
+	flagJoblog            = flag.String("joblog", "", "Write a GNU parallel compatible joblog to the file")
+	flagTraceFile         = flag.String("trace-file", "", "Write a Chrome Trace Event timeline of the run to the file")
+	flagJUnit             = flag.String("junit", "", "Write a JUnit XML report to the file")
+	flagMetricsAddr       = flag.String("metrics-addr", "", "Serve Prometheus metrics on /metrics at the address while the commands run")
+	flagResume            = flag.Bool("resume", false, "Skip the commands that finished in the --joblog or --events-file of a previous run")
+	flagResumeFailed      = flag.Bool("resume-failed", false, "Skip the commands that succeeded in the --joblog or --events-file of a previous run, and run the failed and unfinished ones")
 
-	errUsage               = fmt.Errorf("usage: %s configFile", os.Args[0])
-	errConfigNil           = errors.New("config is nil")
-	errConfigCommandsEmpty = errors.New("config commands is empty")
+	errUsage = fmt.Errorf("usage: %s configFile", os.Args[0])
 )
 
-type config struct {
-	Dir      string   `json:"dir,omitempty" yaml:"dir,omitempty"`
-	Commands []string `json:"commands,omitempty" yaml:"commands,omitempty"`
-}
-
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("")
@@ -49,7 +63,7 @@ func main() {
 	}
 }
 
-func do(ctx context.Context) error {
+func do(ctx context.Context) (retErr error) {
 	if len(flag.Args()) != 1 {
 		log.Fatal(errUsage.Error())
 	}
@@ -59,74 +73,156 @@ func do(ctx context.Context) error {
 		return err
 	}
 
-	if !*flagNoLog {
+	logger, err := getLogger()
+	if err != nil {
+		return err
+	}
+
+	summarySink, err := getSummarySink()
+	if err != nil {
+		return err
+	}
+	var handle pexec.Handle
+	if summarySink != nil {
+		// deferred first so that the summary is written once the
+		// output and the sinks are closed
+		defer func() {
+			if handle == nil {
+				return
+			}
+			summarySink.AddResults(handle.Results())
+			if err := writeSummary(summarySink.Summary()); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+	}
+
+	redactor := getRedactor(config)
+	progressView := getProgressView()
+
+	logging := !*flagNoLog && !*flagTAP && progressView == nil
+	if logging {
 		data, err := json.Marshal(config)
 		if err != nil {
 			return err
 		}
-		log.Print(string(data))
+		configString := string(data)
+		if redactor != nil {
+			configString = redactor.Redact(configString)
+		}
+		if logger != nil {
+			logger.Info("config", "config", configString)
+		} else {
+			log.Print(configString)
+		}
 	}
 
-	cmds, err := getCmds(ctx, config, *flagDir)
+	output, err := newCmdOutput(redactor, progressView)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := output.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	input := newCmdInput(config.Dir)
+	defer input.Close()
+	cmds, err := getCmds(ctx, config, *flagDir, input, output)
 	if err != nil {
 		return err
 	}
 
 	runnerOptions := []pexec.RunnerOption{pexec.WithMaxConcurrentCmds(*flagMaxConcurrentCmds)}
-	if *flagNoLog {
+	if stdinOption := input.Split(os.Stdin); stdinOption != nil {
+		runnerOptions = append(runnerOptions, stdinOption)
+	}
+	if !logging {
 		runnerOptions = append(runnerOptions, pexec.WithEventHandler(func(*pexec.Event) {}))
+	} else if logger != nil {
+		runnerOptions = append(runnerOptions, pexec.WithEventHandler(pexec.NewSlogEventHandler(logger)))
 	}
 
 	if *flagFastFail {
 		runnerOptions = append(runnerOptions, pexec.WithFastFail())
 	}
 
-	return pexec.NewRunner(runnerOptions...).Run(pexec.ExecCmds(ctx, cmds))
-}
-
-func readConfig(configFilePath string) (*config, error) {
-	data, err := ioutil.ReadFile(configFilePath)
-	if err != nil {
-		return nil, err
+	if redactor != nil {
+		runnerOptions = append(runnerOptions, pexec.WithRedactor(redactor))
 	}
 
-	config := &config{}
-	if err := yaml.Unmarshal(data, config); err != nil {
-		return nil, err
+	if *flagFailureOutput > 0 {
+		runnerOptions = append(runnerOptions, pexec.WithOutputCapture(*flagFailureOutput), pexec.WithFailureOutput())
+	} else if *flagSummary != "" && *flagSummaryLines > 0 {
+		// the output is only taken from the results for the summary
+		runnerOptions = append(runnerOptions, pexec.WithOutputCapture(summaryOutputCapture))
 	}
 
-	if config.Dir == "" {
-		config.Dir = filepath.Dir(configFilePath)
-	} else if !filepath.IsAbs(config.Dir) {
-		config.Dir = filepath.Join(filepath.Dir(configFilePath), config.Dir)
+	if summarySink != nil {
+		runnerOptions = append(runnerOptions, pexec.WithEventSubscriber(summarySink.Handle))
 	}
-	if err := validateConfig(config); err != nil {
-		return nil, err
+
+	if *flagRetries > 0 {
+		runnerOptions = append(runnerOptions, pexec.WithRetries(*flagRetries))
 	}
 
-	return config, nil
-}
+	runnerOptions = append(runnerOptions, output.RunnerOptions()...)
 
-func validateConfig(config *config) error {
-	if config == nil {
-		return errConfigNil
+	resumeSkip, err := getResumeSkip(redactor)
+	if err != nil {
+		return err
+	}
+	if resumeSkip != nil {
+		runnerOptions = append(runnerOptions, pexec.WithSkip(resumeSkip))
+	}
+
+	sinks, err := getEventSinks()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := closeEventSinks(sinks); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	for _, sink := range sinks {
+		runnerOptions = append(runnerOptions, pexec.WithEventSubscriber(sink.Handle))
 	}
 
-	if len(config.Commands) == 0 {
-		return errConfigCommandsEmpty
+	if *flagMetricsAddr != "" {
+		m := metrics.New()
+		stopMetrics, err := serveMetrics(*flagMetricsAddr, m)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := stopMetrics(ctx); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+		runnerOptions = append(runnerOptions, pexec.WithEventSubscriber(m.Handle))
 	}
 
-	return nil
+	var ptyCmds []pexec.PTYCmd
+	for _, cmd := range cmds {
+		if ptyCmd, ok := cmd.(pexec.PTYCmd); ok {
+			ptyCmds = append(ptyCmds, ptyCmd)
+		}
+	}
+	stopWatchingWindowSize := watchWindowSize(ptyCmds)
+	defer stopWatchingWindowSize()
+	handle = pexec.NewStarter(runnerOptions...).Start(cmds)
+	return handle.Wait()
 }
 
-func getCmds(ctx context.Context, config *config, dirPath string) ([]*exec.Cmd, error) {
-	var cmds []*exec.Cmd
-	for _, line := range config.Commands {
-		if line == "" {
+func getCmds(ctx context.Context, config *config, dirPath string, input *cmdInput, output *cmdOutput) ([]pexec.Cmd, error) {
+	var cmds []pexec.Cmd
+	for _, command := range config.Commands {
+		if command.Command == "" {
 			continue
 		}
 
-		args, err := shellwords.Parse(line)
+		args, err := shellwords.Parse(command.Command)
 		if err != nil {
 			return nil, err
 		}
@@ -143,10 +239,48 @@ func getCmds(ctx context.Context, config *config, dirPath string) ([]*exec.Cmd,
 			cmd.Dir = config.Dir
 		}
 
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmds = append(cmds, cmd)
+		var pexecCmd pexec.Cmd
+		switch {
+		case command.PTY:
+			var options []pexec.PTYOption
+			if rows, cols, ok := terminalSize(); ok {
+				options = append(options, pexec.WithPTYSize(rows, cols))
+			}
+			pexecCmd = pexec.PTYExecCmd(ctx, command.Name, cmd, options...)
+		case command.Name != "":
+			pexecCmd = pexec.NamedExecCmd(ctx, command.Name, cmd)
+		default:
+			pexecCmd = pexec.ExecCmd(ctx, cmd)
+		}
+		if err := input.Set(command, cmd, pexecCmd); err != nil {
+			return nil, err
+		}
+		cmd.Stdout, cmd.Stderr, err = output.Writers(len(cmds), pexecCmd)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, pexecCmd)
 	}
 
 	return cmds, nil
 }
+
+// getLogger returns the slog logger for --log-format and --log-level,
+// or nil if the events are logged in the event format.
+func getLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*flagLogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid --log-level: %w", err)
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	switch *flagLogFormat {
+	case "event":
+		return nil, nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, handlerOptions)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, handlerOptions)), nil
+	default:
+		return nil, fmt.Errorf("invalid --log-format: %q", *flagLogFormat)
+	}
+}
@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+// prefixColors are the ANSI SGR foreground colour codes cycled through
+// for the tags of the commands.
+var prefixColors = []int{36, 33, 35, 32, 34, 31}
+
+// cmdOutput sets up the stdout and stderr of the commands.
+type cmdOutput struct {
+	Group    pexec.OutputGroup
+	Results  *resultsDir
+	Redactor pexec.Redactor
+	Progress pexec.ProgressView
+	Closers  map[int][]io.Closer
+	Lock     sync.Mutex
+}
+
+func newCmdOutput(redactor pexec.Redactor, progressView pexec.ProgressView) (*cmdOutput, error) {
+	output := &cmdOutput{Redactor: redactor, Progress: progressView, Closers: make(map[int][]io.Closer)}
+	if *flagGroup {
+		var options []pexec.GroupOption
+		if *flagKeepOrder {
+			options = append(options, pexec.WithGroupKeepOrder())
+		}
+		stdout, stderr := output.terminalWriters()
+		output.Group = pexec.NewOutputGroup(stdout, stderr, options...)
+	}
+	if *flagResults != "" {
+		results, err := newResultsDir(*flagResults, redactor)
+		if err != nil {
+			_ = output.Close()
+			return nil, err
+		}
+		output.Results = results
+	}
+	return output, nil
+}
+
+// RunnerOptions returns the RunnerOptions needed for the output.
+func (o *cmdOutput) RunnerOptions() []pexec.RunnerOption {
+	runnerOptions := []pexec.RunnerOption{pexec.WithEventSubscriber(o.Handle)}
+	if o.Progress != nil {
+		runnerOptions = append(runnerOptions, pexec.WithEventSubscriber(o.Progress.Handle))
+	}
+	if o.Results != nil {
+		runnerOptions = append(runnerOptions, pexec.WithEventHook(o.Results.Hook))
+	}
+	return runnerOptions
+}
+
+// Writers returns the stdout and stderr for the command with the ID.
+func (o *cmdOutput) Writers(id int, cmd pexec.Cmd) (io.Writer, io.Writer, error) {
+	var closers []io.Closer
+	stdout, stderr := o.terminalWriters()
+	if o.Group != nil {
+		stdout, stderr = o.Group.Writers(id)
+	}
+	// the progress view needs whole lines
+	if *flagTag || o.Progress != nil {
+		var options []pexec.PrefixOption
+		if *flagColor {
+			options = append(options, pexec.WithPrefixColor(prefixColors[id%len(prefixColors)]))
+		}
+		if *flagTimestamp {
+			options = append(options, pexec.WithPrefixTimestamp("15:04:05.000"))
+		}
+		tag := pexec.CmdTag(id, cmd)
+		prefixStdout := pexec.NewPrefixWriter(stdout, tag, options...)
+		prefixStderr := pexec.NewPrefixWriter(stderr, tag, options...)
+		closers = append(closers, prefixStdout, prefixStderr)
+		stdout, stderr = prefixStdout, prefixStderr
+	}
+	if o.Results != nil {
+		stdoutFile, stderrFile, err := o.Results.Files(id, cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+		stdout = io.MultiWriter(stdout, stdoutFile)
+		stderr = io.MultiWriter(stderr, stderrFile)
+	}
+	// the output is redacted before it is written anywhere, and closed
+	// first so that its last line reaches the other writers
+	if o.Redactor != nil {
+		redactStdout := pexec.NewRedactWriter(stdout, o.Redactor)
+		redactStderr := pexec.NewRedactWriter(stderr, o.Redactor)
+		closers = append([]io.Closer{redactStdout, redactStderr}, closers...)
+		stdout, stderr = redactStdout, redactStderr
+	}
+	o.Lock.Lock()
+	o.Closers[id] = append(o.Closers[id], closers...)
+	o.Lock.Unlock()
+	return stdout, stderr, nil
+}
+
+// terminalWriters returns the writers for the stdout and stderr of
+// pexec, which write above the progress view if it is set.
+//
+// Both are stderr with --tap, as stdout is the TAP stream.
+func (o *cmdOutput) terminalWriters() (io.Writer, io.Writer) {
+	if o.Progress != nil {
+		return o.Progress.Writer(os.Stdout), o.Progress.Writer(os.Stderr)
+	}
+	if *flagTAP {
+		return os.Stderr, os.Stderr
+	}
+	return os.Stdout, os.Stderr
+}
+
+// Handle writes the last line of the output of each finished command
+// if it did not end with a newline, and then passes the event to the
+// output group and results directory if they are set, so that the line
+// is in the block and files of the command.
+func (o *cmdOutput) Handle(event *pexec.Event) {
+	if event.Type == pexec.EventTypeCmdFinished {
+		o.Lock.Lock()
+		for _, closer := range o.Closers[event.CmdID] {
+			_ = closer.Close()
+		}
+		o.Lock.Unlock()
+	}
+	if o.Group != nil {
+		o.Group.Handle(event)
+	}
+	if o.Results != nil {
+		o.Results.Handle(event)
+	}
+}
+
+// Close writes the remaining output of the commands.
+func (o *cmdOutput) Close() error {
+	o.Lock.Lock()
+	defer o.Lock.Unlock()
+	var errs []error
+	for _, closers := range o.Closers {
+		for _, closer := range closers {
+			errs = append(errs, closer.Close())
+		}
+	}
+	if o.Group != nil {
+		errs = append(errs, o.Group.Close())
+	}
+	if o.Results != nil {
+		errs = append(errs, o.Results.Close())
+	}
+	if o.Progress != nil {
+		errs = append(errs, o.Progress.Close())
+	}
+	return errors.Join(errs...)
+}
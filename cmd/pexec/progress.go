@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+// getProgressView returns the ProgressView for --progress, or nil if
+// it is not set, stdout is not a terminal, or --tap is set.
+func getProgressView() pexec.ProgressView {
+	fd := int(os.Stdout.Fd())
+	if !*flagProgress || *flagTAP || !term.IsTerminal(fd) {
+		return nil
+	}
+	var options []pexec.ProgressOption
+	if width, _, err := term.GetSize(fd); err == nil {
+		options = append(options, pexec.WithProgressWidth(width))
+	}
+	return pexec.NewProgressView(os.Stdout, options...)
+}
+
+// terminalSize returns the window size of the terminal of stdout.
+func terminalSize() (uint16, uint16, bool) {
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint16(rows), uint16(cols), true
+}
@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !unix
+
+package main
+
+import pexec "github.com/zchee/go-pexec"
+
+// watchWindowSize does nothing as there is no window size change
+// signal.
+func watchWindowSize(cmds []pexec.PTYCmd) func() {
+	return func() {}
+}
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+// watchWindowSize resizes the PTYs of the commands whenever the window
+// size of the terminal of pexec changes, until the returned function is
+// called.
+func watchWindowSize(cmds []pexec.PTYCmd) func() {
+	if len(cmds) == 0 {
+		return func() {}
+	}
+	signalC := make(chan os.Signal, 1)
+	signal.Notify(signalC, syscall.SIGWINCH)
+	doneC := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-doneC:
+				return
+			case <-signalC:
+				rows, cols, ok := terminalSize()
+				if !ok {
+					continue
+				}
+				for _, cmd := range cmds {
+					// fails if the command is not running
+					_ = cmd.Resize(rows, cols)
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signalC)
+		close(doneC)
+	}
+}
@@ -0,0 +1,281 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+// resultsDir writes the output and result of each command to its own
+// directory, named by the name of the command or its index, in the
+// --results directory.
+type resultsDir struct {
+	Dir      string
+	Redactor pexec.Redactor
+	Names    map[string]struct{}
+	Cmds     map[int]*resultsCmd
+	Err      error
+	Lock     sync.Mutex
+}
+
+type resultsCmd struct {
+	Dir       string
+	Stdout    *resultsFile
+	Stderr    *resultsFile
+	StartTime time.Time
+	// Killed says that the runner killed the command, which has no
+	// error if it was killed at the end of the run.
+	Killed bool
+}
+
+// resultsFile is an output file of a command that keeps only the output
+// of the last attempt, and records write errors instead of returning
+// them so that the output still reaches the other writers.
+type resultsFile struct {
+	File     *os.File
+	Truncate bool
+	Err      error
+	Lock     sync.Mutex
+}
+
+// resultsTiming is the content of the timing.json file of a command.
+type resultsTiming struct {
+	Status        string  `json:"status"`
+	Reason        string  `json:"reason,omitempty"`
+	StartTime     string  `json:"start_time,omitempty"`
+	FinishTime    string  `json:"finish_time"`
+	Seconds       float64 `json:"seconds"`
+	UserSeconds   float64 `json:"user_seconds"`
+	SystemSeconds float64 `json:"system_seconds"`
+	Attempts      int     `json:"attempts,omitempty"`
+}
+
+func newResultsDir(dir string, redactor pexec.Redactor) (*resultsDir, error) {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, err
+	}
+	return &resultsDir{
+		Dir:      dir,
+		Redactor: redactor,
+		Names:    make(map[string]struct{}),
+		Cmds:     make(map[int]*resultsCmd),
+	}, nil
+}
+
+// Files creates the directory of the command with the ID, writes its
+// command line, and returns the writers for its stdout and stderr.
+func (r *resultsDir) Files(id int, cmd pexec.Cmd) (io.Writer, io.Writer, error) {
+	r.Lock.Lock()
+	defer r.Lock.Unlock()
+	dir := filepath.Join(r.Dir, r.name(id, cmd))
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, nil, err
+	}
+	cmdString := cmd.String()
+	if r.Redactor != nil {
+		cmdString = r.Redactor.Redact(cmdString)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cmd"), []byte(cmdString+"\n"), 0o666); err != nil {
+		return nil, nil, err
+	}
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		return nil, nil, err
+	}
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		_ = stdout.Close()
+		return nil, nil, err
+	}
+	c := &resultsCmd{Dir: dir, Stdout: &resultsFile{File: stdout}, Stderr: &resultsFile{File: stderr}}
+	r.Cmds[id] = c
+	return c.Stdout, c.Stderr, nil
+}
+
+// name returns the directory name of the command, which is its name if
+// it is set, unique and a valid file name, or its index otherwise.
+//
+// Must be called with the lock held.
+func (r *resultsDir) name(id int, cmd pexec.Cmd) string {
+	name := strconv.Itoa(id)
+	if namedCmd, ok := cmd.(pexec.NamedCmd); ok {
+		if cmdName := namedCmd.Name(); cmdName != "" && cmdName != "." && cmdName != ".." &&
+			!strings.ContainsAny(cmdName, `/\`) {
+			if _, err := strconv.Atoi(cmdName); err != nil {
+				name = cmdName
+			}
+		}
+	}
+	if _, ok := r.Names[name]; ok {
+		name = strconv.Itoa(id)
+	}
+	r.Names[name] = struct{}{}
+	return name
+}
+
+// Hook makes the next attempt of each retried command drop the output
+// of the failed attempt before it writes.
+//
+// It only marks the files, as it is called under the lock of the
+// command, and must be a hook so that the files are marked before the
+// next attempt starts.
+func (r *resultsDir) Hook(event *pexec.Event, _ pexec.Cmd) {
+	if event.Type != pexec.EventTypeCmdRetrying {
+		return
+	}
+	r.Lock.Lock()
+	cmd, ok := r.Cmds[event.CmdID]
+	r.Lock.Unlock()
+	if ok {
+		cmd.Stdout.reset()
+		cmd.Stderr.reset()
+	}
+}
+
+// Handle writes the exit code and timing of each finished command, and
+// closes its files.
+//
+// The lock is not held while writing so that Hook does not wait on it.
+func (r *resultsDir) Handle(event *pexec.Event) {
+	r.Lock.Lock()
+	cmd, ok := r.Cmds[event.CmdID]
+	if !ok {
+		r.Lock.Unlock()
+		return
+	}
+	switch event.Type {
+	case pexec.EventTypeCmdStarted:
+		cmd.StartTime = event.Time
+		r.Lock.Unlock()
+		return
+	case pexec.EventTypeCmdKilled:
+		cmd.Killed = !event.Cancelled
+		r.Lock.Unlock()
+		return
+	case pexec.EventTypeCmdFinished, pexec.EventTypeCmdSkipped:
+		delete(r.Cmds, event.CmdID)
+		r.Lock.Unlock()
+	default:
+		r.Lock.Unlock()
+		return
+	}
+	// the event is emitted once the command has exited, so all of its
+	// output has been written
+	if err := cmd.write(event); err != nil {
+		r.Lock.Lock()
+		if r.Err == nil {
+			r.Err = err
+		}
+		r.Lock.Unlock()
+	}
+}
+
+// Close closes the files of the commands that did not finish.
+func (r *resultsDir) Close() error {
+	r.Lock.Lock()
+	defer r.Lock.Unlock()
+	errs := []error{r.Err}
+	for id, cmd := range r.Cmds {
+		delete(r.Cmds, id)
+		errs = append(errs, cmd.Stdout.Close(), cmd.Stderr.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// write writes the exit code and timing for the EventTypeCmdFinished
+// or EventTypeCmdSkipped event, and closes the output files.
+func (c *resultsCmd) write(event *pexec.Event) error {
+	timing := &resultsTiming{
+		FinishTime:    event.Time.Format(time.RFC3339Nano),
+		Seconds:       event.Duration.Seconds(),
+		UserSeconds:   event.UserTime.Seconds(),
+		SystemSeconds: event.SystemTime.Seconds(),
+		Attempts:      event.Attempt,
+	}
+	if !c.StartTime.IsZero() {
+		timing.StartTime = c.StartTime.Format(time.RFC3339Nano)
+	}
+	switch {
+	case event.Type == pexec.EventTypeCmdSkipped:
+		timing.Status = "skipped"
+		timing.Reason = event.Reason
+	case event.Cancelled:
+		timing.Status = "cancelled"
+	case c.Killed:
+		timing.Status = "killed"
+	case event.Error != "":
+		timing.Status = "failed"
+	case event.Signal != "":
+		timing.Status = "killed"
+	default:
+		timing.Status = "succeeded"
+	}
+	data, err := json.MarshalIndent(timing, "", "  ")
+	if err != nil {
+		return err
+	}
+	errs := []error{os.WriteFile(filepath.Join(c.Dir, "timing.json"), append(data, '\n'), 0o666)}
+	if event.ExitCode != nil {
+		errs = append(errs, os.WriteFile(filepath.Join(c.Dir, "exitcode"), []byte(fmt.Sprintf("%d\n", *event.ExitCode)), 0o666))
+	}
+	errs = append(errs, c.Stdout.Close(), c.Stderr.Close())
+	return errors.Join(errs...)
+}
+
+func (f *resultsFile) Write(data []byte) (int, error) {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+	f.truncate()
+	if f.Err == nil {
+		if _, err := f.File.Write(data); err != nil {
+			f.Err = err
+		}
+	}
+	return len(data), nil
+}
+
+// Close drops the output of a failed attempt if the next attempt did
+// not write, closes the file, and returns the first error.
+func (f *resultsFile) Close() error {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+	f.truncate()
+	return errors.Join(f.Err, f.File.Close())
+}
+
+// reset makes the next write drop the output written so far.
+func (f *resultsFile) reset() {
+	f.Lock.Lock()
+	defer f.Lock.Unlock()
+	f.Truncate = true
+}
+
+// truncate drops the output written so far if reset was called.
+//
+// Must be called with the lock held.
+func (f *resultsFile) truncate() {
+	if !f.Truncate || f.Err != nil {
+		return
+	}
+	f.Truncate = false
+	if err := f.File.Truncate(0); err != nil {
+		f.Err = err
+		return
+	}
+	if _, err := f.File.Seek(0, io.SeekStart); err != nil {
+		f.Err = err
+	}
+}
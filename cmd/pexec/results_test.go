@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/google/go-cmp/cmp"
+	exec "golang.org/x/sys/execabs"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+func TestResultsDirNames(t *testing.T) {
+	dir := t.TempDir()
+	results, err := newResultsDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for id, name := range []string{"foo", "foo", "", "a/b", "0", ".."} {
+		cmd := pexec.NamedExecCmd(context.Background(), name, exec.Command("true"))
+		if _, _, err := results.Files(id, cmd); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, filepath.Base(results.Cmds[id].Dir))
+	}
+	if err := results.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// names that collide, are not valid file names or are numbers
+	// fall back to the index
+	if diff := cmp.Diff([]string{"foo", "1", "2", "3", "4", "5"}, got); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestResultsDirRun(t *testing.T) {
+	dir := t.TempDir()
+	workDir := t.TempDir()
+	results, err := newResultsDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first attempt of the retried command writes to both streams
+	// and the second only to stdout
+	retried := exec.Command("sh", "-c", "if [ -e marker ]; then echo second; exit 3; fi; touch marker; echo first; echo err >&2; exit 1")
+	retried.Dir = workDir
+	var cmds []pexec.Cmd
+	for id, execCmd := range []*exec.Cmd{exec.Command("echo", "ok"), retried} {
+		cmd := pexec.ExecCmd(context.Background(), execCmd)
+		execCmd.Stdout, execCmd.Stderr, err = results.Files(id, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	runner := pexec.NewRunner(
+		pexec.WithEventHandler(func(*pexec.Event) {}),
+		pexec.WithRetries(1),
+		pexec.WithEventHook(results.Hook),
+		pexec.WithEventSubscriber(results.Handle),
+	)
+	if err := runner.Run(cmds); err == nil {
+		t.Fatal("except err is non-nil")
+	}
+	if err := results.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		file string
+		want string
+	}{
+		{"0/cmd", cmds[0].String() + "\n"},
+		{"0/stdout", "ok\n"},
+		{"0/stderr", ""},
+		{"0/exitcode", "0\n"},
+		{"1/stdout", "second\n"},
+		{"1/stderr", ""},
+		{"1/exitcode", "3\n"},
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(test.want, string(data)); diff != "" {
+			t.Fatalf("%s (-want +got):\n%s", test.file, diff)
+		}
+	}
+
+	for _, test := range []struct {
+		id       string
+		status   string
+		attempts int
+	}{
+		{"0", "succeeded", 1},
+		{"1", "failed", 2},
+	} {
+		timing := readResultsTiming(t, dir, test.id)
+		if timing.Status != test.status || timing.Attempts != test.attempts {
+			t.Fatalf("except %s after %d attempts but got %+v", test.status, test.attempts, timing)
+		}
+		startTime, err := time.Parse(time.RFC3339Nano, timing.StartTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		finishTime, err := time.Parse(time.RFC3339Nano, timing.FinishTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finishTime.Before(startTime) || timing.Seconds <= 0 {
+			t.Fatalf("except finish after start with a duration but got %+v", timing)
+		}
+	}
+}
+
+func TestResultsDirKilled(t *testing.T) {
+	dir := t.TempDir()
+	results, err := newResultsDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first command is killed at the end of the run when the
+	// second one fails
+	var cmds []pexec.Cmd
+	for id, execCmd := range []*exec.Cmd{exec.Command("sleep", "5"), exec.Command("sh", "-c", "sleep 0.1; exit 1")} {
+		cmd := pexec.ExecCmd(context.Background(), execCmd)
+		execCmd.Stdout, execCmd.Stderr, err = results.Files(id, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	runner := pexec.NewRunner(
+		pexec.WithEventHandler(func(*pexec.Event) {}),
+		pexec.WithMaxConcurrentCmds(2),
+		pexec.WithFastFail(),
+		pexec.WithEventSubscriber(results.Handle),
+	)
+	if err := runner.Run(cmds); err == nil {
+		t.Fatal("except err is non-nil")
+	}
+	if err := results.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if timing := readResultsTiming(t, dir, "0"); timing.Status != "killed" {
+		t.Fatalf("except killed but got %+v", timing)
+	}
+	if timing := readResultsTiming(t, dir, "1"); timing.Status != "failed" {
+		t.Fatalf("except failed but got %+v", timing)
+	}
+}
+
+func readResultsTiming(t *testing.T, dir string, id string) *resultsTiming {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, id, "timing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timing := &resultsTiming{}
+	if err := json.Unmarshal(data, timing); err != nil {
+		t.Fatal(err)
+	}
+	return timing
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+// skipReasonResumed is the reason for commands skipped when resuming.
+const skipReasonResumed = "resumed"
+
+var errResumeFileMissing = errors.New("--resume and --resume-failed require --joblog or --events-file")
+
+// cmdIdentity identifies a command across runs of the same config.
+type cmdIdentity struct {
+	ID  int
+	Cmd string
+}
+
+// resuming returns true if --resume or --resume-failed is set, in which
+// case the joblog and events file are appended to.
+func resuming() bool {
+	return *flagResume || *flagResumeFailed
+}
+
+// getResumeSkip returns the skip function for --resume or
+// --resume-failed, or nil if neither is set.
+func getResumeSkip(redactor pexec.Redactor) (func(int, pexec.Cmd) string, error) {
+	if !resuming() {
+		return nil, nil
+	}
+
+	var results map[cmdIdentity]bool
+	var err error
+	switch {
+	case *flagJoblog != "":
+		results, err = readJoblogResults(*flagJoblog)
+	case *flagEventsFile != "":
+		results, err = readEventsFileResults(*flagEventsFile)
+	default:
+		return nil, errResumeFileMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resumeSkip(results, *flagResumeFailed, redactor), nil
+}
+
+// resumeSkip returns the skip function for the results of a previous
+// run.
+//
+// Like GNU parallel, the commands that finished in the previous run are
+// skipped, or only the ones that succeeded if failed is true. The
+// commands that did not finish are always run.
+//
+// The commands in the results are redacted, so the commands are
+// redacted with the redactor if it is set before they are looked up.
+func resumeSkip(results map[cmdIdentity]bool, failed bool, redactor pexec.Redactor) func(int, pexec.Cmd) string {
+	return func(id int, cmd pexec.Cmd) string {
+		cmdString := cmd.String()
+		if redactor != nil {
+			cmdString = redactor.Redact(cmdString)
+		}
+		succeeded, ok := results[cmdIdentity{id, cmdString}]
+		if ok && (succeeded || !failed) {
+			return skipReasonResumed
+		}
+		return ""
+	}
+}
+
+// readJoblogResults reads whether each command in the joblog succeeded.
+//
+// Later entries for the same command take precedence.
+func readJoblogResults(filePath string) (map[cmdIdentity]bool, error) {
+	results := make(map[cmdIdentity]bool)
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return results, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, err := pexec.ReadJoblog(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		results[cmdIdentity{entry.Seq - 1, entry.Command}] = entry.Succeeded()
+	}
+	return results, nil
+}
+
+// readEventsFileResults reads whether each command in the events file
+// succeeded.
+//
+// Later events for the same command take precedence.
+func readEventsFileResults(filePath string) (map[cmdIdentity]bool, error) {
+	results := make(map[cmdIdentity]bool)
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return results, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(filePath, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			// the previous run did not write a whole gzip header
+			return results, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	events, err := pexec.ReadJSONLines(reader)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if event.Type == pexec.EventTypeCmdFinished {
+			// commands killed at the end of the run have no error
+			results[cmdIdentity{event.CmdID, event.Cmd}] = event.Error == "" && event.Signal == "" && !event.Cancelled
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	exec "golang.org/x/sys/execabs"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+func TestResumeSkip(t *testing.T) {
+	cmds := []pexec.Cmd{
+		pexec.ExecCmd(context.Background(), exec.Command("true")),
+		pexec.ExecCmd(context.Background(), exec.Command("false")),
+		pexec.ExecCmd(context.Background(), exec.Command("sleep", "1")),
+	}
+	// the last command did not finish in the previous run
+	results := map[cmdIdentity]bool{
+		{0, cmds[0].String()}: true,
+		{1, cmds[1].String()}: false,
+	}
+
+	for _, tt := range []struct {
+		name   string
+		failed bool
+		want   []string
+	}{
+		{"resume", false, []string{skipReasonResumed, skipReasonResumed, ""}},
+		{"resume failed", true, []string{skipReasonResumed, "", ""}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			skip := resumeSkip(results, tt.failed, nil)
+			var got []string
+			for id, cmd := range cmds {
+				got = append(got, skip(id, cmd))
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResumeSkipRedacted(t *testing.T) {
+	cmd := pexec.ExecCmd(context.Background(), exec.Command("echo", "secret"))
+	redactor := pexec.NewRedactor(pexec.WithRedactValues("secret"))
+	// the events and joblog of the previous run have the redacted
+	// command
+	results := map[cmdIdentity]bool{
+		{0, redactor.Redact(cmd.String())}: true,
+	}
+
+	if got := resumeSkip(results, false, redactor)(0, cmd); got != skipReasonResumed {
+		t.Fatalf("except %q but got %q", skipReasonResumed, got)
+	}
+}
+
+func TestReadEventsFileResultsTruncated(t *testing.T) {
+	// the previous run was killed before it closed the events file
+	buffer := &bytes.Buffer{}
+	sink := pexec.NewJSONLinesSink(buffer, pexec.WithJSONLinesGzip())
+	for _, event := range []*pexec.Event{
+		{Type: pexec.EventTypeStarted, NumCmds: 2},
+		{Type: pexec.EventTypeCmdStarted, CmdID: 0, Cmd: "foo"},
+		{Type: pexec.EventTypeCmdFinished, CmdID: 0, Cmd: "foo"},
+		{Type: pexec.EventTypeCmdStarted, CmdID: 1, Cmd: "bar"},
+	} {
+		sink.Handle(event)
+	}
+	filePath := filepath.Join(t.TempDir(), "events.jsonl.gz")
+	if err := os.WriteFile(filePath, buffer.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := readEventsFileResults(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[cmdIdentity]bool{{0, "foo"}: true}, results); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	// only the gzip header was written
+	if err := os.WriteFile(filePath, buffer.Bytes()[:10], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results, err = readEventsFileResults(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("except no results but got %v", results)
+	}
+}
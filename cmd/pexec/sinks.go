@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+// getEventSinks returns the EventSinks requested by the flags.
+func getEventSinks() (_ []pexec.EventSink, retErr error) {
+	var sinks []pexec.EventSink
+	defer func() {
+		if retErr != nil {
+			_ = closeEventSinks(sinks)
+		}
+	}()
+
+	if *flagTAP {
+		sinks = append(sinks, pexec.NewTAPSink(os.Stdout))
+	}
+
+	if *flagEventsFile != "" {
+		var options []pexec.JSONLinesOption
+		if strings.HasSuffix(*flagEventsFile, ".gz") {
+			options = append(options, pexec.WithJSONLinesGzip())
+		}
+		createSink := pexec.CreateJSONLinesSink
+		if resuming() {
+			createSink = pexec.AppendJSONLinesSink
+		}
+		sink, err := createSink(*flagEventsFile, options...)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if *flagJoblog != "" {
+		createSink := pexec.CreateJoblogSink
+		if resuming() {
+			createSink = pexec.AppendJoblogSink
+		}
+		sink, err := createSink(*flagJoblog)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if *flagTraceFile != "" {
+		sink, err := pexec.CreateTraceEventSink(*flagTraceFile)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if *flagJUnit != "" {
+		sink, err := pexec.CreateJUnitSink(*flagJUnit)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
+
+// closeEventSinks closes all the EventSinks and returns the first error.
+func closeEventSinks(sinks []pexec.EventSink) error {
+	var retErr error
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	return retErr
+}
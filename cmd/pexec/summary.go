@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	json "github.com/goccy/go-json"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+// summaryOutputCapture is the number of bytes of output of each
+// command captured for the --summary if --failure-output is not set.
+const summaryOutputCapture = 16 << 10
+
+// getSummarySink returns the SummarySink for --summary, or nil if it
+// is not set.
+func getSummarySink() (pexec.SummarySink, error) {
+	switch *flagSummary {
+	case "":
+		return nil, nil
+	case "text", "json":
+		return pexec.NewSummarySink(
+			pexec.WithSummarySlowest(*flagSummarySlowest),
+			pexec.WithSummaryOutputLines(*flagSummaryLines),
+		), nil
+	default:
+		return nil, fmt.Errorf("invalid --summary: %q", *flagSummary)
+	}
+}
+
+// writeSummary writes the summary to stdout in the --summary format,
+// or to stderr with --tap.
+func writeSummary(summary *pexec.Summary) error {
+	writer := os.Stdout
+	if *flagTAP {
+		writer = os.Stderr
+	}
+	if *flagSummary == "text" {
+		return summary.WriteText(writer)
+	}
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(data, '\n'))
+	return err
+}
@@ -10,20 +10,99 @@ import (
 	"time"
 )
 
-var errCmdFailed = errors.New("command failed")
+var (
+	errCmdFailed = errors.New("command failed")
+	errCmdKilled = errors.New("command killed")
+)
+
+const (
+	skipReasonFastFail    = "fast_fail"
+	skipReasonInterrupted = "interrupted"
+	skipReasonKilled      = "killed"
+	skipReasonCancelled   = "cancelled"
+)
 
 type cmdController struct {
+	ID           int
 	Cmd          Cmd
-	EventHandler func(*Event)
+	EventHandler func(*Event, Cmd)
 	Clock        func() time.Time
+	MaxAttempts  int
+	Attempt      int
 	Started      bool
 	Finished     bool
-	StartTime    time.Time
+	Killed       bool
+	// Stopped says that the running command was killed, and its final
+	// event is emitted with StopError once it has exited.
+	Stopped   bool
+	Cancelled bool
+	StopError error
+	StartTime time.Time
+	// Stdout and Stderr capture the output of the command if
+	// output capture is set and the command is an OutputCmd.
+	Stdout        *tailBuffer
+	Stderr        *tailBuffer
+	FailureOutput bool
+	// FinalEvent is the EventTypeCmdFinished or EventTypeCmdSkipped
+	// event of the command.
+	FinalEvent *Event
+	// LineWriters split the output of the command into lines for
+	// EventTypeCmdOutput events if output events are set.
+	LineWriters  []*lineWriter
+	RateLimiter  *rateLimiter
+	DroppedLines int
 	Lock         sync.Mutex
 }
 
-func newCmdController(cmd Cmd, eventHandler func(*Event), clock func() time.Time) *cmdController {
-	return &cmdController{cmd, eventHandler, clock, false, false, clock(), sync.Mutex{}}
+func newCmdController(id int, cmd Cmd, eventHandler func(*Event, Cmd), clock func() time.Time, maxAttempts int, outputLimit int, failureOutput bool, outputEvents *outputEventConfig) *cmdController {
+	c := &cmdController{id, cmd, eventHandler, clock, maxAttempts, 1, false, false, false, false, false, nil, clock(), nil, nil, failureOutput, nil, nil, nil, 0, sync.Mutex{}}
+	outputCmd, ok := cmd.(OutputCmd)
+	if !ok {
+		return c
+	}
+	if captureCmd, ok := cmd.(CaptureCmd); ok {
+		if limit, ok := captureCmd.OutputCaptureLimit(); ok {
+			outputLimit = limit
+		}
+	}
+	if outputLimit > 0 {
+		c.Stdout = newTailBuffer(outputLimit)
+		c.Stderr = newTailBuffer(outputLimit)
+		outputCmd.AddOutput(c.Stdout, c.Stderr)
+	}
+	if outputEvents != nil {
+		stdout := newLineWriter(streamStdout, outputEvents.MaxLineLength, c.outputLine)
+		stderr := newLineWriter(streamStderr, outputEvents.MaxLineLength, c.outputLine)
+		c.LineWriters = []*lineWriter{stdout, stderr}
+		if outputEvents.LinesPerSecond > 0 {
+			c.RateLimiter = newRateLimiter(outputEvents.LinesPerSecond, outputEvents.Burst)
+		}
+		outputCmd.AddOutput(stdout, stderr)
+	}
+	return c
+}
+
+// Queue is called when the command is waiting to be run.
+func (c *cmdController) Queue() {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	if c.Started || c.Finished {
+		return
+	}
+	c.handleEvent(newCmdQueuedEvent(c.Clock(), c.ID, c.Cmd))
+}
+
+// Skip is called before the command is run to not run it, and
+// records it as skipped with the given reason.
+func (c *cmdController) Skip(reason string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	if c.Started || c.Finished {
+		return
+	}
+	c.Started = true
+	c.Finished = true
+	c.handleEvent(newCmdSkippedEvent(c.Clock(), c.ID, c.Cmd, reason, nil))
 }
 
 // Run returns false on failure that has not been already handled
@@ -31,51 +110,214 @@ func (c *cmdController) Run() bool {
 	c.Lock.Lock()
 	if c.Started || c.Finished {
 		c.Lock.Unlock()
-		return true
+		return !c.Killed
 	}
 	c.Started = true
-	c.StartTime = c.Clock()
-	c.EventHandler(newCmdStartedEvent(c.StartTime, c.Cmd))
-	if err := c.Cmd.Start(); err != nil {
+	for {
+		if c.Stdout != nil {
+			c.Stdout.Reset()
+			c.Stderr.Reset()
+		}
+		c.StartTime = c.Clock()
+		c.handleEvent(newCmdStartedEvent(c.StartTime, c.ID, c.Cmd))
+		if err := c.Cmd.Start(); err != nil {
+			finishTime := c.Clock()
+			err = fmt.Errorf("command could not start: %v: %v", c.Cmd, err)
+			if c.retry(finishTime, err) {
+				continue
+			}
+			c.Finished = true
+			c.handleEvent(newCmdFinishedEvent(finishTime, c.ID, c.Cmd, c.StartTime, err))
+			c.Lock.Unlock()
+			return false
+		}
+		c.Lock.Unlock()
+		err := c.Cmd.Wait()
+		for _, lineWriter := range c.LineWriters {
+			lineWriter.Flush()
+		}
 		finishTime := c.Clock()
-		err = fmt.Errorf("command could not start: %v: %v", c.Cmd, err)
+		if err != nil {
+			err = fmt.Errorf("command had error: %v: %v", c.Cmd, err)
+		}
+		c.Lock.Lock()
+		if c.Stopped {
+			c.Finished = true
+			c.handleEvent(newCmdStoppedEvent(finishTime, c.ID, c.Cmd, c.StartTime, c.Cancelled, c.StopError))
+			c.Lock.Unlock()
+			return !c.Killed
+		}
+		if err != nil && c.retry(finishTime, err) {
+			continue
+		}
 		c.Finished = true
-		c.EventHandler(newCmdFinishedEvent(finishTime, c.Cmd, c.StartTime, err))
+		c.handleEvent(newCmdFinishedEvent(finishTime, c.ID, c.Cmd, c.StartTime, err))
 		c.Lock.Unlock()
+		return err == nil
+	}
+}
+
+// retry replaces the command with a new one for the next attempt
+// if the command failed with err and can be retried.
+//
+// Must be called with the lock held.
+func (c *cmdController) retry(finishTime time.Time, err error) bool {
+	if c.Attempt >= c.MaxAttempts {
 		return false
 	}
-	c.Lock.Unlock()
-	err := c.Cmd.Wait()
-	finishTime := c.Clock()
-	if err != nil {
-		err = fmt.Errorf("command had error: %v: %v", c.Cmd, err)
+	retryableCmd, ok := c.Cmd.(RetryableCmd)
+	if !ok {
+		return false
 	}
+	cmd, retryErr := retryableCmd.Retry()
+	if retryErr != nil {
+		return false
+	}
+	c.handleEvent(newCmdRetryingEvent(finishTime, c.ID, c.Cmd, c.StartTime, err))
+	c.Cmd = cmd
+	c.Attempt++
+	return true
+}
+
+// Running returns true if the command has started and not yet finished.
+func (c *cmdController) Running() bool {
 	c.Lock.Lock()
 	defer c.Lock.Unlock()
-	if c.Finished {
-		return true
-	}
-	c.Finished = true
-	c.EventHandler(newCmdFinishedEvent(finishTime, c.Cmd, c.StartTime, err))
-	return err == nil
+	return c.Started && !c.Finished
 }
 
-func (c *cmdController) Kill() {
+// Kill is called when the runner is done and kills the command if
+// it is still running, or skips it with the given reason if it has
+// not yet started.
+//
+// The EventTypeCmdFinished event of a killed command is emitted by Run
+// once the command has exited.
+func (c *cmdController) Kill(reason string) {
 	c.Lock.Lock()
 	defer c.Lock.Unlock()
 	if !c.Started {
 		c.Started = true
 		c.Finished = true
+		c.handleEvent(newCmdSkippedEvent(c.Clock(), c.ID, c.Cmd, reason, nil))
 		return
 	}
-	if c.Finished {
+	if c.Finished || c.Stopped {
 		return
 	}
-	c.Finished = true
+	c.Stopped = true
 	err := c.Cmd.Kill()
-	finishTime := c.Clock()
 	if err != nil {
 		err = fmt.Errorf("command had error on kill: %v: %v", c.Cmd, err)
 	}
-	c.EventHandler(newCmdFinishedEvent(finishTime, c.Cmd, c.StartTime, err))
+	c.StopError = err
+	c.handleEvent(newCmdKilledEvent(c.Clock(), c.ID, c.Cmd, false, err))
+}
+
+// Stop is called by a caller while the runner is still running, and
+// kills the command, or prevents it from starting if it has not yet
+// started.
+//
+// If cancel is true, the command is recorded as cancelled and does not
+// fail the run, otherwise it is recorded as failed.
+//
+// The EventTypeCmdFinished event of a killed command is emitted by Run
+// once the command has exited.
+func (c *cmdController) Stop(cancel bool) error {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	if c.Finished || c.Stopped {
+		return nil
+	}
+	c.Killed = !cancel
+	if !c.Started {
+		c.Started = true
+		c.Finished = true
+		reason := skipReasonKilled
+		var err error
+		if cancel {
+			reason = skipReasonCancelled
+		} else {
+			err = fmt.Errorf("%w: %v", errCmdKilled, c.Cmd)
+		}
+		event := newCmdSkippedEvent(c.Clock(), c.ID, c.Cmd, reason, err)
+		event.Cancelled = cancel
+		c.handleEvent(event)
+		return nil
+	}
+	c.Stopped = true
+	c.Cancelled = cancel
+	killErr := c.Cmd.Kill()
+	var killedErr error
+	switch {
+	case killErr != nil:
+		c.StopError = fmt.Errorf("command had error on kill: %v: %v", c.Cmd, killErr)
+		killedErr = c.StopError
+	case !cancel:
+		c.StopError = fmt.Errorf("%w: %v", errCmdKilled, c.Cmd)
+	}
+	c.handleEvent(newCmdKilledEvent(c.Clock(), c.ID, c.Cmd, cancel, killedErr))
+	return killErr
+}
+
+// outputLine is called with each line of output of the command if
+// output events are set, and emits an EventTypeCmdOutput event for it
+// unless it is over the rate limit.
+func (c *cmdController) outputLine(stream string, line string) {
+	c.Lock.Lock()
+	if c.Finished {
+		c.Lock.Unlock()
+		return
+	}
+	t := c.Clock()
+	if c.RateLimiter != nil && !c.RateLimiter.Allow(t) {
+		c.DroppedLines++
+		c.Lock.Unlock()
+		return
+	}
+	// read under the lock as a retry replaces the command
+	cmd := c.Cmd
+	event := newCmdOutputEvent(t, c.ID, cmd, stream, line, c.DroppedLines)
+	event.Attempt = c.Attempt
+	c.DroppedLines = 0
+	c.Lock.Unlock()
+	// published without the lock so that the command is not held up
+	// by the handlers of its output
+	c.EventHandler(event, cmd)
+}
+
+// Result returns the result of the command.
+func (c *cmdController) Result() *CmdResult {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	result := &CmdResult{ID: c.ID, Event: c.FinalEvent}
+	if c.Stdout != nil {
+		result.Stdout, result.StdoutTruncated = c.Stdout.Tail()
+		result.Stderr, result.StderrTruncated = c.Stderr.Tail()
+	}
+	return result
+}
+
+// handleEvent sets the attempt on the command event and handles it.
+//
+// The captured output is set on failed EventTypeCmdFinished events
+// if failure output is set.
+//
+// Must be called with the lock held.
+func (c *cmdController) handleEvent(event *Event) {
+	event.Attempt = c.Attempt
+	switch event.Type {
+	case EventTypeCmdFinished, EventTypeCmdSkipped:
+		if event.Type == EventTypeCmdFinished && event.Error != "" && c.FailureOutput && c.Stdout != nil {
+			stdout, _ := c.Stdout.Tail()
+			stderr, _ := c.Stderr.Tail()
+			event.Stdout = string(stdout)
+			event.Stderr = string(stderr)
+		}
+		if event.Type == EventTypeCmdFinished {
+			event.DroppedLines = c.DroppedLines
+			c.DroppedLines = 0
+		}
+		c.FinalEvent = event
+	}
+	c.EventHandler(event, c.Cmd)
 }
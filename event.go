@@ -3,35 +3,348 @@
 
 package pexec
 
-import "time"
+import (
+	"fmt"
+	"time"
 
-func newEvent(e EventType, t time.Time, f map[string]interface{}, err error) *Event {
+	json "github.com/goccy/go-json"
+)
+
+func newEvent(e EventType, t time.Time, err error) *Event {
 	var errString string
 	if err != nil {
 		errString = err.Error()
 	}
-	return &Event{e, t, f, errString}
+	return &Event{Type: e, Time: t, Error: errString}
+}
+
+func newStartedEvent(t time.Time, numCmds int, maxConcurrentCmds int) *Event {
+	event := newEvent(EventTypeStarted, t, nil)
+	event.NumCmds = numCmds
+	event.MaxConcurrentCmds = maxConcurrentCmds
+	return event
+}
+
+func newCmdEvent(e EventType, t time.Time, id int, cmd Cmd, err error) *Event {
+	event := newEvent(e, t, err)
+	event.CmdID = id
+	event.Cmd = cmd.String()
+	event.Name = cmdName(cmd)
+	return event
+}
+
+func newCmdQueuedEvent(t time.Time, id int, cmd Cmd) *Event {
+	return newCmdEvent(EventTypeCmdQueued, t, id, cmd, nil)
+}
+
+func newCmdSkippedEvent(t time.Time, id int, cmd Cmd, reason string, err error) *Event {
+	event := newCmdEvent(EventTypeCmdSkipped, t, id, cmd, err)
+	event.Reason = reason
+	return event
+}
+
+func newCmdStartedEvent(t time.Time, id int, cmd Cmd) *Event {
+	return newCmdEvent(EventTypeCmdStarted, t, id, cmd, nil)
 }
 
-func newStartedEvent(t time.Time) *Event {
-	return newEvent(EventTypeStarted, t, nil, nil)
+func newCmdFinishedEvent(t time.Time, id int, cmd Cmd, startTime time.Time, err error) *Event {
+	event := newCmdEvent(EventTypeCmdFinished, t, id, cmd, err)
+	event.Duration = t.Sub(startTime)
+	setProcessState(event, cmd)
+	return event
 }
 
-func newCmdStartedEvent(t time.Time, cmd Cmd) *Event {
-	return newEvent(EventTypeCmdStarted, t, map[string]interface{}{
-		"cmd": cmd.String(),
-	}, nil)
+func newCmdRetryingEvent(t time.Time, id int, cmd Cmd, startTime time.Time, err error) *Event {
+	event := newCmdEvent(EventTypeCmdRetrying, t, id, cmd, err)
+	event.Duration = t.Sub(startTime)
+	setProcessState(event, cmd)
+	return event
 }
 
-func newCmdFinishedEvent(t time.Time, cmd Cmd, startTime time.Time, err error) *Event {
-	return newEvent(EventTypeCmdFinished, t, map[string]interface{}{
-		"cmd":      cmd.String(),
-		"duration": t.Sub(startTime).String(),
-	}, err)
+func newCmdKilledEvent(t time.Time, id int, cmd Cmd, cancelled bool, err error) *Event {
+	event := newCmdEvent(EventTypeCmdKilled, t, id, cmd, err)
+	event.Cancelled = cancelled
+	return event
+}
+
+func newCmdStoppedEvent(t time.Time, id int, cmd Cmd, startTime time.Time, cancelled bool, err error) *Event {
+	event := newCmdFinishedEvent(t, id, cmd, startTime, err)
+	event.Cancelled = cancelled
+	return event
+}
+
+func newCmdOutputEvent(t time.Time, id int, cmd Cmd, stream string, line string, droppedLines int) *Event {
+	event := newCmdEvent(EventTypeCmdOutput, t, id, cmd, nil)
+	event.Stream = stream
+	event.Line = line
+	event.DroppedLines = droppedLines
+	return event
 }
 
 func newFinishedEvent(t time.Time, startTime time.Time, err error) *Event {
-	return newEvent(EventTypeFinished, t, map[string]interface{}{
-		"duration": t.Sub(startTime).String(),
-	}, err)
+	event := newEvent(EventTypeFinished, t, err)
+	event.Duration = t.Sub(startTime)
+	return event
+}
+
+// cmdName returns the name of the Cmd if it is a NamedCmd.
+func cmdName(cmd Cmd) string {
+	if namedCmd, ok := cmd.(NamedCmd); ok {
+		return namedCmd.Name()
+	}
+	return ""
+}
+
+// failureType returns the type of failure of the failed
+// EventTypeCmdFinished event.
+func failureType(event *Event) string {
+	switch {
+	case event.Signal != "":
+		return "signal: " + event.Signal
+	case event.ExitCode != nil:
+		return fmt.Sprintf("exit code %d", *event.ExitCode)
+	default:
+		return "error"
+	}
+}
+
+// encodedEvent is the JSON and YAML encoding of an Event.
+type encodedEvent struct {
+	Type   EventType              `json:"type,omitempty" yaml:"type,omitempty"`
+	Time   time.Time              `json:"time,omitempty" yaml:"time,omitempty"`
+	Fields map[string]interface{} `json:"fields,omitempty" yaml:"fields,omitempty"`
+	Error  string                 `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// MarshalJSON marshals the Event to JSON.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.encode())
+}
+
+// UnmarshalJSON unmarshals the Event from JSON.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	encoded := &encodedEvent{}
+	if err := json.Unmarshal(data, encoded); err != nil {
+		return err
+	}
+	return e.decode(encoded)
+}
+
+// MarshalYAML marshals the Event to YAML.
+func (e Event) MarshalYAML() (interface{}, error) {
+	return e.encode(), nil
+}
+
+// UnmarshalYAML unmarshals the Event from YAML.
+func (e *Event) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	encoded := &encodedEvent{}
+	if err := unmarshal(encoded); err != nil {
+		return err
+	}
+	return e.decode(encoded)
+}
+
+func (e Event) encode() *encodedEvent {
+	typedFields := e.encodeFields()
+	fields := make(map[string]interface{}, len(e.Fields)+len(typedFields))
+	for key, value := range e.Fields {
+		fields[key] = value
+	}
+	for _, field := range typedFields {
+		fields[field.Key] = field.Value
+	}
+	if len(fields) == 0 {
+		fields = nil
+	}
+	return &encodedEvent{e.Type, e.Time, fields, e.Error}
+}
+
+// eventField is an encoded field of an Event.
+type eventField struct {
+	Key   string
+	Value interface{}
+}
+
+// encodeFields returns the encoded typed fields of the Event that are
+// set, in a stable order.
+func (e Event) encodeFields() []eventField {
+	var fields []eventField
+	add := func(key string, value interface{}) {
+		fields = append(fields, eventField{key, value})
+	}
+	if e.NumCmds != 0 {
+		add("num_cmds", e.NumCmds)
+	}
+	if e.MaxConcurrentCmds != 0 {
+		add("max_concurrent_cmds", e.MaxConcurrentCmds)
+	}
+	if e.Type.isCmd() {
+		add("cmd_id", e.CmdID)
+	}
+	if e.Cmd != "" {
+		add("cmd", e.Cmd)
+	}
+	if e.Name != "" {
+		add("name", e.Name)
+	}
+	if e.Attempt != 0 {
+		add("attempt", e.Attempt)
+	}
+	if e.Type.hasDuration() {
+		add("duration", e.Duration.String())
+	}
+	if e.Reason != "" {
+		add("reason", e.Reason)
+	}
+	if e.Cancelled {
+		add("cancelled", true)
+	}
+	if e.PID != 0 {
+		add("pid", e.PID)
+		add("user_time", e.UserTime.String())
+		add("system_time", e.SystemTime.String())
+	}
+	if e.ExitCode != nil {
+		add("exit_code", *e.ExitCode)
+	}
+	if e.Signal != "" {
+		add("signal", e.Signal)
+	}
+	if e.SignalNumber != 0 {
+		add("signal_number", e.SignalNumber)
+	}
+	if e.MaxRSS != 0 {
+		add("max_rss", e.MaxRSS)
+	}
+	if e.Stream != "" {
+		add("stream", e.Stream)
+	}
+	if e.Type == EventTypeCmdOutput {
+		add("line", e.Line)
+	}
+	if e.DroppedLines != 0 {
+		add("dropped_lines", e.DroppedLines)
+	}
+	if e.Stdout != "" {
+		add("stdout", e.Stdout)
+	}
+	if e.Stderr != "" {
+		add("stderr", e.Stderr)
+	}
+	if e.DroppedEvents != 0 {
+		add("dropped_events", e.DroppedEvents)
+	}
+	return fields
+}
+
+func (e *Event) decode(encoded *encodedEvent) (err error) {
+	*e = Event{Type: encoded.Type, Time: encoded.Time, Error: encoded.Error}
+	for key, value := range encoded.Fields {
+		switch key {
+		case "num_cmds":
+			e.NumCmds, err = fieldInt(key, value)
+		case "max_concurrent_cmds":
+			e.MaxConcurrentCmds, err = fieldInt(key, value)
+		case "cmd_id":
+			e.CmdID, err = fieldInt(key, value)
+		case "cmd":
+			e.Cmd, err = fieldString(key, value)
+		case "name":
+			e.Name, err = fieldString(key, value)
+		case "attempt":
+			e.Attempt, err = fieldInt(key, value)
+		case "duration":
+			e.Duration, err = fieldDuration(key, value)
+		case "reason":
+			e.Reason, err = fieldString(key, value)
+		case "cancelled":
+			e.Cancelled, err = fieldBool(key, value)
+		case "pid":
+			e.PID, err = fieldInt(key, value)
+		case "user_time":
+			e.UserTime, err = fieldDuration(key, value)
+		case "system_time":
+			e.SystemTime, err = fieldDuration(key, value)
+		case "exit_code":
+			var exitCode int
+			exitCode, err = fieldInt(key, value)
+			e.ExitCode = &exitCode
+		case "signal":
+			e.Signal, err = fieldString(key, value)
+		case "signal_number":
+			e.SignalNumber, err = fieldInt(key, value)
+		case "max_rss":
+			var maxRSS int
+			maxRSS, err = fieldInt(key, value)
+			e.MaxRSS = int64(maxRSS)
+		case "stream":
+			e.Stream, err = fieldString(key, value)
+		case "line":
+			e.Line, err = fieldString(key, value)
+		case "dropped_lines":
+			e.DroppedLines, err = fieldInt(key, value)
+		case "stdout":
+			e.Stdout, err = fieldString(key, value)
+		case "stderr":
+			e.Stderr, err = fieldString(key, value)
+		case "dropped_events":
+			e.DroppedEvents, err = fieldInt(key, value)
+		default:
+			if e.Fields == nil {
+				e.Fields = make(map[string]interface{})
+			}
+			e.Fields[key] = value
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fieldString(key string, value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", invalidField(key, value)
+	}
+	return s, nil
+}
+
+func fieldBool(key string, value interface{}) (bool, error) {
+	b, ok := value.(bool)
+	if !ok {
+		return false, invalidField(key, value)
+	}
+	return b, nil
+}
+
+func fieldInt(key string, value interface{}) (int, error) {
+	switch n := value.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case uint64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	default:
+		return 0, invalidField(key, value)
+	}
+}
+
+func fieldDuration(key string, value interface{}) (time.Duration, error) {
+	s, ok := value.(string)
+	if !ok {
+		return 0, invalidField(key, value)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, invalidField(key, value)
+	}
+	return d, nil
+}
+
+func invalidField(key string, value interface{}) error {
+	return fmt.Errorf("invalid Event field %s: %v", key, value)
 }
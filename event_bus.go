@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import "sync"
+
+type subscriberConfig struct {
+	Handler        func(*Event)
+	BufferSize     int
+	OverflowPolicy OverflowPolicy
+}
+
+func newSubscriberConfig(handler func(*Event), options ...SubscriberOption) *subscriberConfig {
+	config := &subscriberConfig{
+		handler,
+		DefaultSubscriberBufferSize,
+		DefaultSubscriberOverflowPolicy,
+	}
+	for _, option := range options {
+		option(config)
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1
+	}
+	return config
+}
+
+// eventBus delivers events to each subscriber on its own goroutine.
+type eventBus struct {
+	subscribers []*subscriber
+	wg          sync.WaitGroup
+}
+
+func newEventBus(configs []*subscriberConfig) *eventBus {
+	bus := &eventBus{}
+	for _, config := range configs {
+		subscriber := newSubscriber(config)
+		bus.subscribers = append(bus.subscribers, subscriber)
+		bus.wg.Add(1)
+		go func() {
+			defer bus.wg.Done()
+			subscriber.deliver()
+		}()
+	}
+	return bus
+}
+
+// Publish queues the event for all subscribers.
+func (b *eventBus) Publish(event *Event) {
+	for _, subscriber := range b.subscribers {
+		subscriber.publish(event)
+	}
+}
+
+// Close waits for all queued events to be delivered.
+//
+// No events may be published after Close is called.
+func (b *eventBus) Close() {
+	for _, subscriber := range b.subscribers {
+		subscriber.close()
+	}
+	b.wg.Wait()
+}
+
+type subscriber struct {
+	config  *subscriberConfig
+	queue   []*Event
+	dropped int
+	closed  bool
+	lock    sync.Mutex
+	// signalled when an event is queued or the subscriber is closed
+	notEmpty *sync.Cond
+	// signalled when an event is taken from the queue
+	notFull *sync.Cond
+}
+
+func newSubscriber(config *subscriberConfig) *subscriber {
+	s := &subscriber{config: config}
+	s.notEmpty = sync.NewCond(&s.lock)
+	s.notFull = sync.NewCond(&s.lock)
+	return s
+}
+
+func (s *subscriber) publish(event *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.queue) >= s.config.BufferSize {
+		// run events are never dropped or coalesced
+		if event.Type.isCmd() {
+			switch s.config.OverflowPolicy {
+			case OverflowPolicyDrop:
+				s.dropped++
+				return
+			case OverflowPolicyCoalesce:
+				if i := s.coalesceIndex(event); i >= 0 {
+					s.queue[i] = event
+					return
+				}
+			}
+		}
+		s.notFull.Wait()
+	}
+	s.queue = append(s.queue, event)
+	s.notEmpty.Signal()
+}
+
+// coalesceIndex returns the index of the newest queued event that
+// the given event can replace, or -1 if there is none.
+//
+// Must be called with the lock held.
+func (s *subscriber) coalesceIndex(event *Event) int {
+	for i := len(s.queue) - 1; i >= 0; i-- {
+		queued := s.queue[i]
+		if queued.CmdID != event.CmdID || !queued.Type.isCmd() {
+			continue
+		}
+		if queued.Type == event.Type {
+			return i
+		}
+		// do not reorder an event past an earlier event for the same command
+		return -1
+	}
+	return -1
+}
+
+func (s *subscriber) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	s.notEmpty.Signal()
+}
+
+func (s *subscriber) deliver() {
+	for {
+		s.lock.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.notEmpty.Wait()
+		}
+		if len(s.queue) == 0 {
+			s.lock.Unlock()
+			return
+		}
+		event := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		if event.Type == EventTypeFinished && s.dropped > 0 {
+			// copy as the event is shared with the other subscribers
+			droppedEvent := *event
+			droppedEvent.DroppedEvents = s.dropped
+			event = &droppedEvent
+		}
+		s.notFull.Signal()
+		s.lock.Unlock()
+		s.config.Handler(event)
+	}
+}
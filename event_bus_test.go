@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEventBusBlock(t *testing.T) {
+	first := newTestEventHandler()
+	second := newTestEventHandler()
+	eventBus := newEventBus([]*subscriberConfig{
+		newSubscriberConfig(first.Handle, WithSubscriberBufferSize(1)),
+		newSubscriberConfig(second.Handle),
+	})
+	publishTestEvents(eventBus, 100)
+	publishTestFinishedEvent(eventBus)
+	eventBus.Close()
+
+	for _, eventHandler := range []*testEventHandler{first, second} {
+		eventHandler.StartedEventSuccess(t)
+		eventHandler.FinishedEventSuccess(t)
+		cmdEvents := eventHandler.NumEventsForType(t, EventTypeCmdOutput, 100)
+		for i, event := range cmdEvents {
+			if event.CmdID != i {
+				t.Fatalf("except command ID %d but got %d", i, event.CmdID)
+			}
+		}
+	}
+}
+
+func TestEventBusDrop(t *testing.T) {
+	eventHandler := newTestEventHandler()
+	unblockC := make(chan struct{})
+	eventBus := newEventBus([]*subscriberConfig{
+		newSubscriberConfig(
+			func(event *Event) {
+				<-unblockC
+				eventHandler.Handle(event)
+			},
+			WithSubscriberBufferSize(10),
+			WithSubscriberOverflowPolicy(OverflowPolicyDrop),
+		),
+	})
+	publishTestEvents(eventBus, 100)
+	close(unblockC)
+	publishTestFinishedEvent(eventBus)
+	eventBus.Close()
+
+	eventHandler.StartedEventSuccess(t)
+	finishedEvent := eventHandler.FinishedEventSuccess(t)
+	numCmdEvents := len(eventHandler.EventsForType(EventTypeCmdOutput))
+	if numCmdEvents == 100 || numCmdEvents+finishedEvent.DroppedEvents != 100 {
+		t.Fatalf("except 100 delivered or dropped events but got %d delivered and %d dropped", numCmdEvents, finishedEvent.DroppedEvents)
+	}
+}
+
+func TestEventBusCoalesce(t *testing.T) {
+	eventHandler := newTestEventHandler()
+	unblockC := make(chan struct{})
+	eventBus := newEventBus([]*subscriberConfig{
+		newSubscriberConfig(
+			func(event *Event) {
+				<-unblockC
+				eventHandler.Handle(event)
+			},
+			WithSubscriberBufferSize(2),
+			WithSubscriberOverflowPolicy(OverflowPolicyCoalesce),
+		),
+	})
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	eventBus.Publish(newStartedEvent(startTime, 3, 0))
+	for i := 0; i < 10; i++ {
+		event := newCmdEvent(EventTypeCmdOutput, startTime.Add(time.Duration(i)), 0, testCmd("foo"), nil)
+		event.Attempt = i
+		eventBus.Publish(event)
+	}
+	close(unblockC)
+	eventBus.Publish(newFinishedEvent(startTime, startTime, nil))
+	eventBus.Close()
+
+	var attempts []int
+	for _, event := range eventHandler.EventsForType(EventTypeCmdOutput) {
+		attempts = append(attempts, event.Attempt)
+	}
+	if len(attempts) == 0 || attempts[len(attempts)-1] != 9 {
+		t.Fatalf("except last event to be delivered but got attempts %v", attempts)
+	}
+	if diff := cmp.Diff(1, len(eventHandler.EventsForType(EventTypeFinished))); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func publishTestEvents(eventBus *eventBus, num int) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	eventBus.Publish(newStartedEvent(startTime, 3, 0))
+	for i := 0; i < num; i++ {
+		eventBus.Publish(newCmdEvent(EventTypeCmdOutput, startTime, i, testCmd("foo"), nil))
+	}
+}
+
+func publishTestFinishedEvent(eventBus *eventBus) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	eventBus.Publish(newFinishedEvent(startTime, startTime, nil))
+}
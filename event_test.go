@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	yaml "github.com/goccy/go-yaml"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEventJSON(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	event := newCmdFinishedEvent(startTime.Add(1500*time.Millisecond), 0, testCmd("foo bar"), startTime, nil)
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("could not json marshal: %v", err)
+	}
+	want := `{"type":"cmd_finished","time":"2021-04-01T00:00:01.5Z","fields":{"cmd":"foo bar","cmd_id":0,"duration":"1.5s"}}`
+	if diff := cmp.Diff(want, string(data)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestEventValue(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	event := newCmdFinishedEvent(startTime.Add(1500*time.Millisecond), 0, testCmd("foo bar"), startTime, nil)
+
+	want, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("could not json marshal: %v", err)
+	}
+	data, err := json.Marshal(*event)
+	if err != nil {
+		t.Fatalf("could not json marshal: %v", err)
+	}
+	if diff := cmp.Diff(string(want), string(data)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	data, err = json.Marshal([]Event{*event})
+	if err != nil {
+		t.Fatalf("could not json marshal: %v", err)
+	}
+	if diff := cmp.Diff("["+string(want)+"]", string(data)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	want, err = yaml.Marshal(event)
+	if err != nil {
+		t.Fatalf("could not yaml marshal: %v", err)
+	}
+	data, err = yaml.Marshal(*event)
+	if err != nil {
+		t.Fatalf("could not yaml marshal: %v", err)
+	}
+	if diff := cmp.Diff(string(want), string(data)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestEventRoundTrip(t *testing.T) {
+	exitCode := 2
+	event := &Event{
+		Type:       EventTypeCmdFinished,
+		Time:       time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+		Error:      "command had error",
+		CmdID:      3,
+		Cmd:        "foo bar",
+		Name:       "foo",
+		Attempt:    1,
+		Duration:   1500 * time.Millisecond,
+		PID:        1234,
+		ExitCode:   &exitCode,
+		UserTime:   10 * time.Millisecond,
+		SystemTime: 20 * time.Millisecond,
+		MaxRSS:     4096,
+		Stdout:     "out\n",
+		Stderr:     "err\n",
+		Fields: map[string]interface{}{
+			"extra": "value",
+		},
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("could not json marshal: %v", err)
+	}
+	unmarshalledEvent := &Event{}
+	if err := json.Unmarshal(data, unmarshalledEvent); err != nil {
+		t.Fatalf("could not json unmarshal: %v", err)
+	}
+	if diff := cmp.Diff(event, unmarshalledEvent); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	data, err = yaml.Marshal(event)
+	if err != nil {
+		t.Fatalf("could not yaml marshal: %v", err)
+	}
+	unmarshalledEvent = &Event{}
+	if err := yaml.Unmarshal(data, unmarshalledEvent); err != nil {
+		t.Fatalf("could not yaml unmarshal: %v", err)
+	}
+	if diff := cmp.Diff(event, unmarshalledEvent); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+type testCmd string
+
+func (c testCmd) String() string { return string(c) }
+func (c testCmd) Start() error   { return nil }
+func (c testCmd) Wait() error    { return nil }
+func (c testCmd) Kill() error    { return nil }
+
+// testRunStartTime is the start time of the run of testRunEvents.
+var testRunStartTime = time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+
+// testRunEvents returns the events of a run for the tests of the event
+// sinks, with five commands:
+//
+//   - 0 foo succeeds
+//   - 1 bar fails, is retried, and fails again with exit code 2
+//   - 2 baz is skipped by fast-fail
+//   - 3 qux is killed at the end of the run, and has no error
+//   - 4 quux is cancelled while it is running
+func testRunEvents() []*Event {
+	at := func(d time.Duration) time.Time {
+		return testRunStartTime.Add(d)
+	}
+	exitCode := func(code int) *int {
+		return &code
+	}
+
+	barRetrying := newCmdRetryingEvent(at(1500*time.Millisecond), 1, testCmd("bar"), at(time.Second), errors.New("command had error: bar"))
+	barRetrying.ExitCode = exitCode(1)
+	barRetrying.Attempt = 1
+	barStarted := newCmdStartedEvent(at(1500*time.Millisecond), 1, testCmd("bar"))
+	barStarted.Attempt = 2
+	barFinished := newCmdFinishedEvent(at(2500*time.Millisecond), 1, testCmd("bar"), at(1500*time.Millisecond), errors.New("command had error: bar"))
+	barFinished.ExitCode = exitCode(2)
+	barFinished.Attempt = 2
+	barFinished.Stdout = "bar out\n"
+	barFinished.Stderr = "bar err\n"
+	quuxStopped := newCmdStoppedEvent(at(2*time.Second), 4, testCmd("quux"), at(time.Second), true, nil)
+	quuxStopped.ExitCode = exitCode(-1)
+	quuxStopped.Signal = "killed"
+	quuxStopped.SignalNumber = 9
+	quxStopped := newCmdStoppedEvent(at(3*time.Second), 3, testCmd("qux"), at(time.Second), false, nil)
+	quxStopped.ExitCode = exitCode(-1)
+	quxStopped.Signal = "killed"
+	quxStopped.SignalNumber = 9
+	fooFinished := newCmdFinishedEvent(at(3*time.Second), 0, testCmd("foo"), at(0), nil)
+	fooFinished.ExitCode = exitCode(0)
+
+	return []*Event{
+		newStartedEvent(at(0), 5, 0),
+		newCmdStartedEvent(at(0), 0, testCmd("foo")),
+		newCmdStartedEvent(at(time.Second), 1, testCmd("bar")),
+		newCmdStartedEvent(at(time.Second), 3, testCmd("qux")),
+		newCmdStartedEvent(at(time.Second), 4, testCmd("quux")),
+		barRetrying,
+		barStarted,
+		newCmdKilledEvent(at(2*time.Second), 4, testCmd("quux"), true, nil),
+		quuxStopped,
+		barFinished,
+		fooFinished,
+		newCmdSkippedEvent(at(3*time.Second), 2, testCmd("baz"), skipReasonFastFail, nil),
+		newCmdKilledEvent(at(3*time.Second), 3, testCmd("qux"), false, nil),
+		quxStopped,
+		newFinishedEvent(at(3*time.Second), at(0), errCmdFailed),
+	}
+}
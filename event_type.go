@@ -18,6 +18,16 @@ const (
 	EventTypeCmdFinished
 	// EventTypeFinished says that the runner finished.
 	EventTypeFinished
+	// EventTypeCmdQueued says that a command is waiting for a slot to run.
+	EventTypeCmdQueued
+	// EventTypeCmdSkipped says that a command was not run.
+	EventTypeCmdSkipped
+	// EventTypeCmdKilled says that a running command was killed.
+	EventTypeCmdKilled
+	// EventTypeCmdRetrying says that a command failed and will be run again.
+	EventTypeCmdRetrying
+	// EventTypeCmdOutput says that a command wrote output.
+	EventTypeCmdOutput
 )
 
 var allEventTypes = []EventType{
@@ -25,6 +35,11 @@ var allEventTypes = []EventType{
 	EventTypeCmdStarted,
 	EventTypeCmdFinished,
 	EventTypeFinished,
+	EventTypeCmdQueued,
+	EventTypeCmdSkipped,
+	EventTypeCmdKilled,
+	EventTypeCmdRetrying,
+	EventTypeCmdOutput,
 }
 
 // EventType is an event type during the runner's run call.
@@ -41,19 +56,60 @@ func (e EventType) String() string {
 		return "cmd_finished"
 	case EventTypeFinished:
 		return "finished"
+	case EventTypeCmdQueued:
+		return "cmd_queued"
+	case EventTypeCmdSkipped:
+		return "cmd_skipped"
+	case EventTypeCmdKilled:
+		return "cmd_killed"
+	case EventTypeCmdRetrying:
+		return "cmd_retrying"
+	case EventTypeCmdOutput:
+		return "cmd_output"
 	default:
 		return strconv.Itoa(int(e))
 	}
 }
 
+// isCmd returns true if the EventType is for a command.
+func (e EventType) isCmd() bool {
+	switch e {
+	case EventTypeCmdStarted,
+		EventTypeCmdFinished,
+		EventTypeCmdQueued,
+		EventTypeCmdSkipped,
+		EventTypeCmdKilled,
+		EventTypeCmdRetrying,
+		EventTypeCmdOutput:
+		return true
+	default:
+		return false
+	}
+}
+
+// hasDuration returns true if events of the EventType have a duration.
+func (e EventType) hasDuration() bool {
+	switch e {
+	case EventTypeCmdFinished, EventTypeCmdRetrying, EventTypeFinished:
+		return true
+	default:
+		return false
+	}
+}
+
 // MarshalJSON marshals the EventType to JSON.
 func (e EventType) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + e.String() + `"`), nil
 }
 
 // UnmarshalJSON unmarshals the EventType from JSON.
+//
+// The unquoted text encoding is also accepted.
 func (e *EventType) UnmarshalJSON(data []byte) error {
 	dataString := strings.ToLower(string(data))
+	if !strings.HasPrefix(dataString, `"`) {
+		dataString = `"` + dataString + `"`
+	}
 	switch dataString {
 	case `"started"`:
 		*e = EventTypeStarted
@@ -63,6 +119,16 @@ func (e *EventType) UnmarshalJSON(data []byte) error {
 		*e = EventTypeCmdFinished
 	case `"finished"`:
 		*e = EventTypeFinished
+	case `"cmd_queued"`:
+		*e = EventTypeCmdQueued
+	case `"cmd_skipped"`:
+		*e = EventTypeCmdSkipped
+	case `"cmd_killed"`:
+		*e = EventTypeCmdKilled
+	case `"cmd_retrying"`:
+		*e = EventTypeCmdRetrying
+	case `"cmd_output"`:
+		*e = EventTypeCmdOutput
 	default:
 		return invalidEventType(data, "json")
 	}
@@ -86,6 +152,16 @@ func (e *EventType) UnmarshalText(data []byte) error {
 		*e = EventTypeCmdFinished
 	case "finished":
 		*e = EventTypeFinished
+	case "cmd_queued":
+		*e = EventTypeCmdQueued
+	case "cmd_skipped":
+		*e = EventTypeCmdSkipped
+	case "cmd_killed":
+		*e = EventTypeCmdKilled
+	case "cmd_retrying":
+		*e = EventTypeCmdRetrying
+	case "cmd_output":
+		*e = EventTypeCmdOutput
 	default:
 		return invalidEventType(data, "text")
 	}
@@ -4,6 +4,7 @@
 package pexec
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -46,3 +47,30 @@ func TestEventType(t *testing.T) {
 		})
 	}
 }
+
+func TestEventTypeString(t *testing.T) {
+	for _, eventType := range allEventTypes {
+		if _, err := strconv.Atoi(eventType.String()); err == nil {
+			t.Fatalf("no string representation for EventType %d", int(eventType))
+		}
+	}
+}
+
+func TestEventTypeText(t *testing.T) {
+	for _, eventType := range allEventTypes {
+		t.Run(eventType.String(), func(t *testing.T) {
+			data, err := eventType.MarshalText()
+			if err != nil {
+				t.Fatalf("could not text marshal: %v", err)
+			}
+
+			var unmarshalledEventType EventType
+			if err := (&unmarshalledEventType).UnmarshalText(data); err != nil {
+				t.Fatalf("could not text unmarshal: %v", err)
+			}
+			if diff := cmp.Diff(eventType, unmarshalledEventType); diff != "" {
+				t.Fatalf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -5,6 +5,10 @@ package pexec
 
 import (
 	"context"
+	"errors"
+	"io"
+	"os"
+	osexec "os/exec"
 	"strings"
 
 	exec "golang.org/x/sys/execabs"
@@ -12,10 +16,50 @@ import (
 
 type execCmd struct {
 	*exec.Cmd
+
+	ctx            context.Context
+	name           string
+	outputLimit    int
+	hasOutputLimit bool
+	// defaultWaitDelay says that WaitDelay was set to DefaultWaitDelay
+	// by Start.
+	defaultWaitDelay bool
+}
+
+func newExecCmd(ctx context.Context, cmd *exec.Cmd, name string, options ...ExecCmdOption) *execCmd {
+	execCmd := &execCmd{cmd, ctx, name, 0, false, false}
+	for _, option := range options {
+		option(execCmd)
+	}
+	return execCmd
 }
 
-func newExecCmd(ctx context.Context, cmd *exec.Cmd) *execCmd {
-	return &execCmd{cmd}
+func (e *execCmd) Name() string {
+	return e.name
+}
+
+func (e *execCmd) OutputCaptureLimit() (int, bool) {
+	return e.outputLimit, e.hasOutputLimit
+}
+
+func (e *execCmd) Start() error {
+	// bound the wait for children of a killed command that still have
+	// its output open, which is set before the start as Wait reads it
+	if e.WaitDelay == 0 {
+		e.WaitDelay = DefaultWaitDelay
+		e.defaultWaitDelay = true
+	}
+	return e.Cmd.Start()
+}
+
+func (e *execCmd) Wait() error {
+	err := e.Cmd.Wait()
+	// the command exited successfully, and only its children still
+	// had its output open
+	if e.defaultWaitDelay && errors.Is(err, osexec.ErrWaitDelay) {
+		return nil
+	}
+	return err
 }
 
 func (e *execCmd) Kill() error {
@@ -25,6 +69,61 @@ func (e *execCmd) Kill() error {
 	return nil
 }
 
+func (e *execCmd) AddEnv(env ...string) {
+	if e.Env == nil {
+		e.Env = os.Environ()
+	}
+	e.Env = append(e.Env, env...)
+}
+
+func (e *execCmd) SetStdin(stdin io.Reader) {
+	e.Stdin = stdin
+}
+
+func (e *execCmd) AddOutput(stdout io.Writer, stderr io.Writer) {
+	e.Stdout = addWriter(e.Stdout, stdout)
+	e.Stderr = addWriter(e.Stderr, stderr)
+}
+
+func (e *execCmd) Retry() (Cmd, error) {
+	var cmd *exec.Cmd
+	if e.ctx != nil {
+		cmd = exec.CommandContext(e.ctx, e.Path)
+	} else {
+		cmd = exec.Command(e.Path)
+	}
+	cmd.Args = e.Args
+	cmd.Env = e.Env
+	cmd.Dir = e.Dir
+	// rewind the stdin if possible so that each attempt reads all of
+	// it, and a file that cannot seek, such as a pipe, is read on
+	if seeker, ok := e.Stdin.(io.Seeker); ok {
+		_, _ = seeker.Seek(0, io.SeekStart)
+	}
+	cmd.Stdin = e.Stdin
+	cmd.Stdout = e.Stdout
+	cmd.Stderr = e.Stderr
+	cmd.ExtraFiles = e.ExtraFiles
+	cmd.SysProcAttr = e.SysProcAttr
+	if !e.defaultWaitDelay {
+		cmd.WaitDelay = e.WaitDelay
+	}
+	return &execCmd{cmd, e.ctx, e.name, e.outputLimit, e.hasOutputLimit, false}, nil
+}
+
 func (e *execCmd) String() string {
 	return strings.Join(append([]string{e.Path}, e.Args...), " ")
 }
+
+func (e *execCmd) processState() *os.ProcessState {
+	return e.ProcessState
+}
+
+// addWriter returns a writer that writes to both writers, or to added
+// if writer is nil.
+func addWriter(writer io.Writer, added io.Writer) io.Writer {
+	if writer == nil {
+		return added
+	}
+	return io.MultiWriter(writer, added)
+}
@@ -0,0 +1,410 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// errFanOutDetached is returned by the reads of an attempt of a command
+// once it has finished.
+var errFanOutDetached = errors.New("fan out attempt detached")
+
+// FanOutOption is an option for FanOut.
+type FanOutOption func(*fanOutConfig)
+
+// WithFanOutBlocks returns a FanOutOption that will split the reader
+// into blocks of at least blockSize bytes that end at a newline, rather
+// than into lines.
+func WithFanOutBlocks(blockSize int) FanOutOption {
+	return func(config *fanOutConfig) {
+		config.BlockSize = blockSize
+	}
+}
+
+// WithFanOutBufferSize returns a FanOutOption that will make FanOut
+// buffer at most about size bytes that have not been read for each
+// reader.
+//
+// The default is DefaultFanOutBufferSize.
+func WithFanOutBufferSize(size int) FanOutOption {
+	return func(config *fanOutConfig) {
+		config.BufferSize = size
+	}
+}
+
+// WithFanOutRetries returns a FanOutOption that will make FanOutStdin
+// keep the lines read by each command until it finishes, so that its
+// retries read them again. Set it with WithRetries.
+func WithFanOutRetries() FanOutOption {
+	return func(config *fanOutConfig) {
+		config.Retries = true
+	}
+}
+
+// FanOut returns n readers that each read every nth line of the reader,
+// round-robin, like GNU parallel's --pipe.
+//
+// The reader is read on its own goroutine, and its content is buffered
+// in memory until it is read so that a reader that is not read, such as
+// the stdin of a command that has not started yet, does not block the
+// others. A line is dealt to the next reader that has room in its
+// buffer, and reading the reader waits while all of them are full. A
+// read error of the reader is returned by all the readers once they
+// have read their content.
+//
+// Close a reader that will not be read to deal the lines it has not
+// read to the others.
+func FanOut(reader io.Reader, n int, options ...FanOutOption) []io.ReadCloser {
+	fanOut := newFanOut(reader, n, options...)
+	readers := make([]io.ReadCloser, n)
+	for i, share := range fanOut.shares {
+		readers[i] = share
+	}
+	return readers
+}
+
+// FanOutStdin splits the reader across the commands like FanOut, and
+// returns the RunnerOption that makes the Runner give each command its
+// share as its stdin.
+//
+// Each attempt of a command reads its share through a pipe, so the
+// command does not wait for its stdin once it has exited. The lines of
+// a command that is skipped, or that have not been written to its pipe
+// when it finishes, are dealt to the others. Commands that are not
+// InputCmds are treated as skipped.
+func FanOutStdin(reader io.Reader, cmds []Cmd, options ...FanOutOption) RunnerOption {
+	stdin := &fanOutStdin{
+		fanOut: newFanOut(reader, len(cmds), options...),
+		cmds:   cmds,
+		ids:    make(map[int]*fanOutShare),
+	}
+	return WithEventHook(stdin.hook)
+}
+
+type fanOutConfig struct {
+	BlockSize  int
+	BufferSize int
+	Retries    bool
+}
+
+// fanOut deals the chunks of a reader to its shares.
+type fanOut struct {
+	reader *bufio.Reader
+	config *fanOutConfig
+	shares []*fanOutShare
+	next   int
+	done   bool
+	err    error
+	lock   sync.Mutex
+	cond   *sync.Cond
+}
+
+func newFanOut(reader io.Reader, n int, options ...FanOutOption) *fanOut {
+	config := &fanOutConfig{BufferSize: DefaultFanOutBufferSize}
+	for _, option := range options {
+		option(config)
+	}
+	f := &fanOut{reader: bufio.NewReader(reader), config: config, shares: make([]*fanOutShare, n)}
+	f.cond = sync.NewCond(&f.lock)
+	for i := range f.shares {
+		f.shares[i] = &fanOutShare{fanOut: f}
+	}
+	if n > 0 {
+		go f.run()
+	}
+	return f
+}
+
+func (f *fanOut) run() {
+	var err error
+	for err == nil {
+		var chunk []byte
+		chunk, err = f.readChunk()
+		if len(chunk) > 0 && !f.deal(chunk) {
+			// all of the shares are closed
+			return
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.done = true
+	f.err = err
+	f.cond.Broadcast()
+}
+
+func (f *fanOut) readChunk() ([]byte, error) {
+	if f.config.BlockSize <= 0 {
+		return f.reader.ReadBytes('\n')
+	}
+	chunk := make([]byte, f.config.BlockSize)
+	n, err := io.ReadFull(f.reader, chunk)
+	chunk = chunk[:n]
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err == nil && chunk[len(chunk)-1] != '\n' {
+		var rest []byte
+		rest, err = f.reader.ReadBytes('\n')
+		chunk = append(chunk, rest...)
+	}
+	return chunk, err
+}
+
+// deal gives the chunk to the next open share that has room, waiting
+// while all of them are full, and returns false if all of them are
+// closed.
+func (f *fanOut) deal(chunk []byte) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for {
+		open := false
+		for i := range f.shares {
+			index := (f.next + i) % len(f.shares)
+			share := f.shares[index]
+			if share.closed {
+				continue
+			}
+			open = true
+			if share.size == 0 || share.size+len(chunk) <= f.config.BufferSize {
+				share.push(chunk)
+				f.next = (index + 1) % len(f.shares)
+				f.cond.Broadcast()
+				return true
+			}
+		}
+		if !open {
+			return false
+		}
+		f.cond.Wait()
+	}
+}
+
+// fanOutShare is the reader for one share of a FanOut.
+//
+// The fields are guarded by the lock of the FanOut.
+type fanOutShare struct {
+	fanOut *fanOut
+	chunks [][]byte
+	size   int
+	// offset is how much of the first chunk has been read.
+	offset int
+	// history is the chunks read by the current attempt of the
+	// command if retries are kept, including the first chunk once it
+	// is being read.
+	history [][]byte
+	// attempt is increased when the attempt reading the share is
+	// detached.
+	attempt int
+	closed  bool
+}
+
+func (s *fanOutShare) Read(p []byte) (int, error) {
+	s.fanOut.lock.Lock()
+	attempt := s.attempt
+	s.fanOut.lock.Unlock()
+	return s.read(p, attempt)
+}
+
+// read reads the share for the attempt, and returns errFanOutDetached
+// once the attempt is detached.
+func (s *fanOutShare) read(p []byte, attempt int) (int, error) {
+	f := s.fanOut
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for len(s.chunks) == 0 && !f.done && !s.closed && s.attempt == attempt {
+		f.cond.Wait()
+	}
+	switch {
+	case s.attempt != attempt:
+		return 0, errFanOutDetached
+	case s.closed:
+		return 0, os.ErrClosed
+	case len(s.chunks) == 0:
+		if f.err != nil {
+			return 0, f.err
+		}
+		return 0, io.EOF
+	}
+	chunk := s.chunks[0]
+	if s.offset == 0 && f.config.Retries {
+		s.history = append(s.history, chunk)
+	}
+	n := copy(p, chunk[s.offset:])
+	s.offset += n
+	s.size -= n
+	if s.offset == len(chunk) {
+		s.chunks[0] = nil
+		s.chunks = s.chunks[1:]
+		s.offset = 0
+	}
+	f.cond.Broadcast()
+	return n, nil
+}
+
+// Close deals the chunks that have not been read to the other open
+// shares.
+func (s *fanOutShare) Close() error {
+	s.fanOut.lock.Lock()
+	defer s.fanOut.lock.Unlock()
+	s.close()
+	return nil
+}
+
+// close deals the chunks that have not been read to the other open
+// shares, regardless of their room, and drops them if there are none.
+//
+// Must be called with the lock of the FanOut held.
+func (s *fanOutShare) close() {
+	if s.closed {
+		return
+	}
+	f := s.fanOut
+	s.closed = true
+	s.attempt++
+	chunks := s.chunks
+	if s.offset > 0 {
+		chunks[0] = chunks[0][s.offset:]
+	}
+	s.chunks, s.size, s.offset, s.history = nil, 0, 0, nil
+	for _, chunk := range chunks {
+		for i := range f.shares {
+			share := f.shares[(f.next+i)%len(f.shares)]
+			if !share.closed {
+				share.push(chunk)
+				f.next = (f.next + i + 1) % len(f.shares)
+				break
+			}
+		}
+	}
+	f.cond.Broadcast()
+}
+
+// rewind detaches the current attempt, and makes the next one read
+// the chunks read by the current one again if retries are kept.
+//
+// Must be called with the lock of the FanOut held.
+func (s *fanOutShare) rewind() {
+	s.attempt++
+	if len(s.history) > 0 {
+		chunks := s.chunks
+		// the first chunk is in the history once it is being read
+		if s.offset > 0 {
+			chunks = chunks[1:]
+		}
+		s.chunks = append(s.history, chunks...)
+		s.history = nil
+		s.offset = 0
+		s.size = 0
+		for _, chunk := range s.chunks {
+			s.size += len(chunk)
+		}
+	}
+	s.fanOut.cond.Broadcast()
+}
+
+// push adds the chunk to the share.
+//
+// Must be called with the lock of the FanOut held.
+func (s *fanOutShare) push(chunk []byte) {
+	s.chunks = append(s.chunks, chunk)
+	s.size += len(chunk)
+}
+
+// fanOutStdin gives the shares of a FanOut to the attempts of the
+// commands as their stdin.
+type fanOutStdin struct {
+	fanOut *fanOut
+	cmds   []Cmd
+	// ids are the shares by the IDs of the commands once they have
+	// started, as retries replace the Cmds.
+	ids   map[int]*fanOutShare
+	pipes map[*fanOutShare]*os.File
+}
+
+func (s *fanOutStdin) hook(event *Event, cmd Cmd) {
+	f := s.fanOut
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	share, ok := s.ids[event.CmdID]
+	if !ok {
+		for i, shareCmd := range s.cmds {
+			if shareCmd == cmd {
+				share, ok = f.shares[i], true
+				break
+			}
+		}
+		if !ok {
+			return
+		}
+	}
+	switch event.Type {
+	case EventTypeCmdStarted:
+		s.ids[event.CmdID] = share
+		s.detach(share)
+		inputCmd, ok := cmd.(InputCmd)
+		if !ok {
+			share.close()
+			return
+		}
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			// the command reads nothing, and its lines go to the
+			// others
+			share.close()
+			inputCmd.SetStdin(nil)
+			return
+		}
+		if s.pipes == nil {
+			s.pipes = make(map[*fanOutShare]*os.File)
+		}
+		s.pipes[share] = reader
+		inputCmd.SetStdin(reader)
+		go copyFanOutShare(writer, share, share.attempt)
+	case EventTypeCmdRetrying:
+		s.detach(share)
+		share.rewind()
+	case EventTypeCmdFinished, EventTypeCmdSkipped:
+		s.detach(share)
+		share.close()
+	}
+}
+
+// detach closes the pipe of the running attempt of the share, so that
+// the copy to it stops once the command has exited.
+//
+// Must be called with the lock of the FanOut held.
+func (s *fanOutStdin) detach(share *fanOutShare) {
+	if reader, ok := s.pipes[share]; ok {
+		delete(s.pipes, share)
+		_ = reader.Close()
+		share.attempt++
+		s.fanOut.cond.Broadcast()
+	}
+}
+
+// copyFanOutShare copies the share to the pipe of the attempt until it
+// ends, the attempt is detached or the command stops reading.
+func copyFanOutShare(writer *os.File, share *fanOutShare, attempt int) {
+	defer writer.Close()
+	buffer := make([]byte, 32<<10)
+	for {
+		n, err := share.read(buffer, attempt)
+		if n > 0 {
+			if _, err := writer.Write(buffer[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
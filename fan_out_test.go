@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	exec "golang.org/x/sys/execabs"
+)
+
+func TestFanOut(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		options []FanOutOption
+		want    []string
+	}{
+		{
+			name: "lines",
+			want: []string{"a\nccc\ne", "bb\ndddd\n"},
+		},
+		{
+			name:    "blocks",
+			options: []FanOutOption{WithFanOutBlocks(3)},
+			want:    []string{"a\nbb\ndddd\n", "ccc\ne"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			readers := FanOut(strings.NewReader("a\nbb\nccc\ndddd\ne"), 2, test.options...)
+			// read the last reader first to check that the others do not block
+			got := make([]string, len(readers))
+			for i := len(readers) - 1; i >= 0; i-- {
+				data, err := io.ReadAll(readers[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[i] = string(data)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Fatalf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFanOutBackpressure(t *testing.T) {
+	reader, writer := io.Pipe()
+	readers := FanOut(reader, 2, WithFanOutBufferSize(100))
+	written := make(chan struct{})
+	go func() {
+		_, _ = writer.Write([]byte(strings.Repeat("x\n", 10000)))
+		_ = writer.Close()
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("except the write to block while the readers are not read")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	lengths := make([]int, len(readers))
+	var wg sync.WaitGroup
+	for i, reader := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				t.Error(err)
+			}
+			lengths[i] = len(data)
+		}()
+	}
+	wg.Wait()
+	<-written
+	if lengths[0]+lengths[1] != 20000 {
+		t.Fatalf("except 20000 bytes but got %v", lengths)
+	}
+}
+
+func TestFanOutClose(t *testing.T) {
+	readers := FanOut(strings.NewReader("a\nbb\nccc\ndddd\ne"), 2)
+	if err := readers[1].Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(readers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	sort.Strings(lines)
+	if diff := cmp.Diff([]string{"a", "bb", "ccc", "dddd", "e"}, lines); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if _, err := readers[1].Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("except os.ErrClosed but got %v", err)
+	}
+}
+
+func TestFanOutStdin(t *testing.T) {
+	workDir := t.TempDir()
+	// the first attempt of the last command fails after reading its
+	// stdin, and the second one reads it again
+	execCmds := []*exec.Cmd{
+		exec.Command("cat"),
+		exec.Command("cat"),
+		exec.Command("sh", "-c", "cat; test -e marker || { touch marker; exit 1; }"),
+	}
+	execCmds[2].Dir = workDir
+	stdouts := make([]*bytes.Buffer, len(execCmds))
+	for i, execCmd := range execCmds {
+		stdouts[i] = &bytes.Buffer{}
+		execCmd.Stdout = stdouts[i]
+	}
+	cmds := ExecCmds(context.Background(), execCmds)
+	runner := NewRunner(
+		WithEventHandler(func(*Event) {}),
+		WithMaxConcurrentCmds(1),
+		WithRetries(1),
+		WithSkip(func(id int, _ Cmd) string {
+			if id == 1 {
+				return "skipped"
+			}
+			return ""
+		}),
+		FanOutStdin(strings.NewReader("1\n2\n3\n4\n5\n6\n"), cmds, WithFanOutRetries()),
+	)
+	if err := runner.Run(cmds); err != nil {
+		t.Fatal(err)
+	}
+
+	// both attempts of the retried command read the same lines
+	retried := stdouts[2].String()
+	if retried[:len(retried)/2] != retried[len(retried)/2:] {
+		t.Fatalf("except the same lines for both attempts but got %q", retried)
+	}
+	// the lines of the skipped command are dealt to the others
+	lines := strings.Fields(stdouts[0].String() + retried[:len(retried)/2])
+	sort.Strings(lines)
+	if diff := cmp.Diff([]string{"1", "2", "3", "4", "5", "6"}, lines); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if stdouts[1].Len() != 0 {
+		t.Fatalf("except no output of the skipped command but got %q", stdouts[1].String())
+	}
+}
+
+func TestFanOutStdinExit(t *testing.T) {
+	// the stdin never ends
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	cmds := []Cmd{
+		ExecCmd(context.Background(), exec.Command("true")),
+		ExecCmd(context.Background(), exec.Command("true")),
+	}
+	runner := NewRunner(WithEventHandler(func(*Event) {}), FanOutStdin(reader, cmds))
+	done := make(chan error)
+	go func() {
+		done <- runner.Run(cmds)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("except the commands to finish without reading their stdin")
+	}
+}
@@ -1,11 +1,18 @@
 module github.com/zchee/go-pexec
 
-go 1.16
+go 1.23
 
 require (
-	github.com/goccy/go-json v0.4.12
+	github.com/creack/pty v1.1.24
+	github.com/goccy/go-json v0.11.2
 	github.com/goccy/go-yaml v1.8.9
-	github.com/google/go-cmp v0.5.5
-	github.com/mattn/go-shellwords v1.0.11
-	golang.org/x/sys v0.0.0-20210415045647-66c3f260301c
+	github.com/google/go-cmp v0.7.0
+	golang.org/x/sys v0.30.0
+)
+
+require (
+	github.com/fatih/color v1.10.0 // indirect
+	github.com/mattn/go-colorable v0.1.8 // indirect
+	github.com/mattn/go-isatty v0.0.12 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
@@ -0,0 +1,243 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// joblogHeader is the header line of a GNU parallel joblog.
+const joblogHeader = "Seq\tHost\tStarttime\tJobRuntime\tSend\tReceive\tExitval\tSignal\tCommand\n"
+
+// joblogKillSignal is the signal number recorded for commands that
+// the runner killed, as the Cmd is killed with SIGKILL.
+const joblogKillSignal = 9
+
+// NewJoblogSink returns a new EventSink that writes a GNU parallel
+// compatible joblog to the writer.
+//
+// There is one line for each finished command, in the order they
+// finish. Seq is the command ID plus one, and Host is always ":".
+// Close does not close the writer.
+func NewJoblogSink(writer io.Writer) EventSink {
+	return newJoblogSink(writer, nil)
+}
+
+// CreateJoblogSink returns a new EventSink that creates or truncates
+// the file at filePath and writes a GNU parallel compatible joblog to it.
+//
+// The file is closed by Close.
+func CreateJoblogSink(filePath string) (EventSink, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return newJoblogSink(file, file), nil
+}
+
+// AppendJoblogSink returns a new EventSink that appends to the GNU
+// parallel compatible joblog at filePath, creating it if it does not
+// exist.
+//
+// The end of the file left by a run that did not finish is repaired
+// first. The header is only written if the file is empty. The file is
+// closed by Close.
+func AppendJoblogSink(filePath string) (EventSink, error) {
+	file, err := openAppendFile(filePath, false)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	sink := newJoblogSink(file, file)
+	sink.wroteHeader = fileInfo.Size() > 0
+	return sink, nil
+}
+
+// JoblogEntry is a line of a GNU parallel joblog.
+type JoblogEntry struct {
+	Seq        int
+	Host       string
+	StartTime  time.Time
+	JobRuntime time.Duration
+	Send       int64
+	Receive    int64
+	Exitval    int
+	Signal     int
+	Command    string
+}
+
+// Succeeded returns true if the command exited with a zero exit code.
+func (e *JoblogEntry) Succeeded() bool {
+	return e.Exitval == 0 && e.Signal == 0
+}
+
+// ReadJoblog reads the entries of a GNU parallel joblog.
+//
+// Header lines are skipped, so joblogs that were appended to by
+// several runs can be read. A partial last line, such as one written
+// by a run that did not finish, is skipped.
+func ReadJoblog(reader io.Reader) ([]*JoblogEntry, error) {
+	var entries []*JoblogEntry
+	bufferedReader := bufio.NewReader(reader)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := bufferedReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		last := err != nil
+		line = strings.TrimSuffix(line, "\n")
+		if line != "" && line+"\n" != joblogHeader {
+			entry, parseErr := parseJoblogLine(line)
+			switch {
+			case parseErr == nil:
+				entries = append(entries, entry)
+			case !last:
+				return nil, fmt.Errorf("invalid joblog line %d: %v", lineNumber, parseErr)
+			}
+		}
+		if last {
+			return entries, nil
+		}
+	}
+}
+
+func parseJoblogLine(line string) (*JoblogEntry, error) {
+	values := strings.SplitN(line, "\t", 9)
+	if len(values) != 9 {
+		return nil, fmt.Errorf("expected 9 columns but got %d", len(values))
+	}
+	for i := range values[:8] {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	entry := &JoblogEntry{Host: values[1], Command: values[8]}
+	var err error
+	if entry.Seq, err = strconv.Atoi(values[0]); err != nil {
+		return nil, err
+	}
+	startTime, err := strconv.ParseFloat(values[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	entry.StartTime = time.Unix(0, int64(startTime*float64(time.Second)))
+	jobRuntime, err := strconv.ParseFloat(values[3], 64)
+	if err != nil {
+		return nil, err
+	}
+	entry.JobRuntime = time.Duration(jobRuntime * float64(time.Second))
+	if entry.Send, err = strconv.ParseInt(values[4], 10, 64); err != nil {
+		return nil, err
+	}
+	if entry.Receive, err = strconv.ParseInt(values[5], 10, 64); err != nil {
+		return nil, err
+	}
+	if entry.Exitval, err = strconv.Atoi(values[6]); err != nil {
+		return nil, err
+	}
+	if entry.Signal, err = strconv.Atoi(values[7]); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+type joblogSink struct {
+	writer        io.Writer
+	closer        io.Closer
+	wroteHeader   bool
+	cmdStartTimes map[int]time.Time
+	killedCmdIDs  map[int]struct{}
+	err           error
+	lock          sync.Mutex
+}
+
+func newJoblogSink(writer io.Writer, closer io.Closer) *joblogSink {
+	return &joblogSink{
+		writer:        writer,
+		closer:        closer,
+		cmdStartTimes: make(map[int]time.Time),
+		killedCmdIDs:  make(map[int]struct{}),
+	}
+}
+
+func (s *joblogSink) Handle(event *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return
+	}
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		if _, err := io.WriteString(s.writer, joblogHeader); err != nil {
+			s.err = err
+			return
+		}
+	}
+	switch event.Type {
+	case EventTypeCmdStarted:
+		s.cmdStartTimes[event.CmdID] = event.Time
+	case EventTypeCmdKilled:
+		s.killedCmdIDs[event.CmdID] = struct{}{}
+	case EventTypeCmdFinished:
+		s.err = s.writeLine(event)
+	}
+}
+
+func (s *joblogSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.err
+	if s.closer != nil {
+		if closeErr := s.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// writeLine writes the joblog line for a EventTypeCmdFinished event.
+//
+// Must be called with the lock held.
+func (s *joblogSink) writeLine(event *Event) error {
+	startTime, ok := s.cmdStartTimes[event.CmdID]
+	if !ok {
+		startTime = event.Time.Add(-event.Duration)
+	}
+	var exitCode int
+	switch {
+	case event.ExitCode != nil:
+		exitCode = *event.ExitCode
+	case event.Error != "":
+		exitCode = -1
+	}
+	signal := event.SignalNumber
+	if _, ok := s.killedCmdIDs[event.CmdID]; ok && signal == 0 {
+		signal = joblogKillSignal
+	}
+	if signal != 0 {
+		// GNU parallel records the exit value as 0 for commands
+		// terminated by a signal
+		exitCode = 0
+	}
+	_, err := fmt.Fprintf(
+		s.writer,
+		"%d\t:\t%.3f\t%10.3f\t0\t0\t%d\t%d\t%s\n",
+		event.CmdID+1,
+		float64(startTime.UnixNano())/float64(time.Second),
+		event.Duration.Seconds(),
+		exitCode,
+		signal,
+		event.Cmd,
+	)
+	return err
+}
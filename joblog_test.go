@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJoblogSink(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	sink := NewJoblogSink(buffer)
+	for _, event := range testRunEvents() {
+		sink.Handle(event)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the retried command has the start time and runtime of its last
+	// attempt, and the killed commands have signal 9
+	want := []string{
+		"Seq\tHost\tStarttime\tJobRuntime\tSend\tReceive\tExitval\tSignal\tCommand",
+		"5\t:\t1617235201.000\t     1.000\t0\t0\t0\t9\tquux",
+		"2\t:\t1617235201.500\t     1.000\t0\t0\t2\t0\tbar",
+		"1\t:\t1617235200.000\t     3.000\t0\t0\t0\t0\tfoo",
+		"4\t:\t1617235201.000\t     2.000\t0\t0\t0\t9\tqux",
+	}
+	data := buffer.String()
+	if diff := cmp.Diff(want, getLines(t, buffer)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	entries, err := ReadJoblog(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var commands []string
+	var succeeded []bool
+	for _, entry := range entries {
+		commands = append(commands, entry.Command)
+		succeeded = append(succeeded, entry.Succeeded())
+	}
+	if diff := cmp.Diff([]string{"quux", "bar", "foo", "qux"}, commands); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]bool{false, false, true, false}, succeeded); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(time.Second, entries[1].JobRuntime); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestReadJoblogTruncated(t *testing.T) {
+	data := joblogHeader +
+		"1\t:\t1617235200.000\t     4.000\t0\t0\t0\t0\tfoo\n" +
+		"2\t:\t1617235201.000\t     1.5"
+	entries, err := ReadJoblog(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Command != "foo" {
+		t.Fatalf("except the entry of foo but got %+v", entries)
+	}
+	if _, err := ReadJoblog(strings.NewReader(joblogHeader + "2\t:\t1.5\nfoo\n")); err == nil {
+		t.Fatal("except err is non-nil for an invalid line")
+	}
+
+	// a resumed run appends to the joblog
+	filePath := filepath.Join(t.TempDir(), "joblog")
+	if err := os.WriteFile(filePath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := AppendJoblogSink(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	sink.Handle(newCmdFinishedEvent(startTime.Add(time.Second), 1, testCmd("bar"), startTime, nil))
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entries, err = ReadJoblog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var commands []string
+	for _, entry := range entries {
+		commands = append(commands, entry.Command)
+	}
+	if diff := cmp.Diff([]string{"foo", "bar"}, commands); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	json "github.com/goccy/go-json"
+)
+
+// JSONLinesOption is an option for a JSON Lines EventSink.
+type JSONLinesOption func(*jsonLinesConfig)
+
+// WithJSONLinesGzip returns a JSONLinesOption that will compress the
+// output with gzip.
+//
+// The compressed output is flushed after each EventTypeCmdFinished
+// event, so that the events of the finished commands can be read if
+// the run does not finish.
+func WithJSONLinesGzip() JSONLinesOption {
+	return func(config *jsonLinesConfig) {
+		config.Gzip = true
+	}
+}
+
+// NewJSONLinesSink returns a new EventSink that writes each event as
+// one line of JSON to the writer.
+//
+// The writer is synced to stable storage after the EventTypeFinished
+// event if it is an *os.File. Close does not close the writer.
+func NewJSONLinesSink(writer io.Writer, options ...JSONLinesOption) EventSink {
+	return newJSONLinesSink(writer, nil, options...)
+}
+
+// CreateJSONLinesSink returns a new EventSink that creates or truncates
+// the file at filePath and writes each event as one line of JSON to it.
+//
+// The file is synced to stable storage after the EventTypeFinished
+// event, and closed by Close.
+func CreateJSONLinesSink(filePath string, options ...JSONLinesOption) (EventSink, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return newJSONLinesSink(file, file, options...), nil
+}
+
+// AppendJSONLinesSink returns a new EventSink that appends each event
+// as one line of JSON to the file at filePath, creating it if it does
+// not exist.
+//
+// The end of the file left by a run that did not finish is repaired
+// first. The file is synced to stable storage after the
+// EventTypeFinished event, and closed by Close.
+func AppendJSONLinesSink(filePath string, options ...JSONLinesOption) (EventSink, error) {
+	file, err := openAppendFile(filePath, newJSONLinesConfig(options...).Gzip)
+	if err != nil {
+		return nil, err
+	}
+	return newJSONLinesSink(file, file, options...), nil
+}
+
+// ReadJSONLines reads events written as JSON Lines.
+//
+// If the reader is gzip compressed, it should be wrapped in a
+// gzip.Reader first. The events written by a run that did not finish
+// are read up to a partial last line or the end of a truncated gzip
+// stream.
+func ReadJSONLines(reader io.Reader) ([]*Event, error) {
+	var events []*Event
+	bufferedReader := bufio.NewReader(reader)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := bufferedReader.ReadBytes('\n')
+		if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		last := err != nil
+		if len(bytes.TrimSpace(line)) == 0 {
+			if last {
+				return events, nil
+			}
+			continue
+		}
+		event := &Event{}
+		if err := json.Unmarshal(line, event); err != nil {
+			if last {
+				// the partial last line of a run that did not finish
+				return events, nil
+			}
+			return nil, fmt.Errorf("invalid JSON line %d: %v", lineNumber, err)
+		}
+		events = append(events, event)
+		if last {
+			return events, nil
+		}
+	}
+}
+
+type jsonLinesConfig struct {
+	Gzip bool
+}
+
+func newJSONLinesConfig(options ...JSONLinesOption) *jsonLinesConfig {
+	config := &jsonLinesConfig{}
+	for _, option := range options {
+		option(config)
+	}
+	return config
+}
+
+type jsonLinesSink struct {
+	writer     io.Writer
+	closer     io.Closer
+	gzipWriter *gzip.Writer
+	err        error
+	lock       sync.Mutex
+}
+
+func newJSONLinesSink(writer io.Writer, closer io.Closer, options ...JSONLinesOption) *jsonLinesSink {
+	sink := &jsonLinesSink{writer: writer, closer: closer}
+	if newJSONLinesConfig(options...).Gzip {
+		sink.gzipWriter = gzip.NewWriter(writer)
+	}
+	return sink
+}
+
+func (s *jsonLinesSink) Handle(event *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		s.err = err
+		return
+	}
+	if _, err := s.output().Write(append(data, '\n')); err != nil {
+		s.err = err
+		return
+	}
+	switch event.Type {
+	case EventTypeCmdFinished:
+		if s.gzipWriter != nil {
+			s.err = s.gzipWriter.Flush()
+		}
+	case EventTypeFinished:
+		s.err = s.sync()
+	}
+}
+
+func (s *jsonLinesSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.err
+	if s.gzipWriter != nil {
+		if gzipErr := s.gzipWriter.Close(); err == nil {
+			err = gzipErr
+		}
+	}
+	if s.closer != nil {
+		if closeErr := s.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (s *jsonLinesSink) output() io.Writer {
+	if s.gzipWriter != nil {
+		return s.gzipWriter
+	}
+	return s.writer
+}
+
+// sync flushes any compressed data and syncs the writer to stable
+// storage if it is a file.
+//
+// Must be called with the lock held.
+func (s *jsonLinesSink) sync() error {
+	if s.gzipWriter != nil {
+		if err := s.gzipWriter.Flush(); err != nil {
+			return err
+		}
+	}
+	if file, ok := s.writer.(*os.File); ok {
+		return file.Sync()
+	}
+	return nil
+}
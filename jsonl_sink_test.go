@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONLinesSink(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		buffer := &bytes.Buffer{}
+		var options []JSONLinesOption
+		if gzipped {
+			options = append(options, WithJSONLinesGzip())
+		}
+		sink := NewJSONLinesSink(buffer, options...)
+		events := []*Event{
+			newStartedEvent(time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), 1, 0),
+			newCmdStartedEvent(time.Date(2021, 4, 1, 0, 0, 1, 0, time.UTC), 0, testCmd("foo")),
+			newFinishedEvent(time.Date(2021, 4, 1, 0, 0, 2, 0, time.UTC), time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), nil),
+		}
+		for _, event := range events {
+			sink.Handle(event)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var reader io.Reader = buffer
+		if gzipped {
+			gzipReader, err := gzip.NewReader(buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader = gzipReader
+		}
+		gotEvents, err := ReadJSONLines(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(events, gotEvents); diff != "" {
+			t.Fatalf("(-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestReadJSONLinesTruncated(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	events := []*Event{
+		newStartedEvent(startTime, 2, 0),
+		newCmdStartedEvent(startTime, 0, testCmd("foo")),
+		newCmdFinishedEvent(startTime.Add(time.Second), 0, testCmd("foo"), startTime, nil),
+		newCmdStartedEvent(startTime.Add(time.Second), 1, testCmd("bar")),
+	}
+	resumedEvent := newStartedEvent(startTime.Add(time.Minute), 2, 0)
+	for _, gzipped := range []bool{false, true} {
+		// the run does not finish, and the sink is not closed
+		buffer := &bytes.Buffer{}
+		var options []JSONLinesOption
+		if gzipped {
+			options = append(options, WithJSONLinesGzip())
+		}
+		sink := NewJSONLinesSink(buffer, options...)
+		for _, event := range events {
+			sink.Handle(event)
+		}
+		if !gzipped {
+			// the last line is partially written
+			buffer.Truncate(buffer.Len() - 10)
+		}
+		filePath := filepath.Join(t.TempDir(), "events.jsonl")
+		if err := os.WriteFile(filePath, buffer.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(events[:3], readJSONLinesFile(t, filePath, gzipped)); diff != "" {
+			t.Fatalf("(-want +got):\n%s", diff)
+		}
+
+		// a resumed run appends to the file
+		sink, err := AppendJSONLinesSink(filePath, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink.Handle(resumedEvent)
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(append(events[:3:3], resumedEvent), readJSONLinesFile(t, filePath, gzipped)); diff != "" {
+			t.Fatalf("(-want +got):\n%s", diff)
+		}
+	}
+}
+
+func readJSONLinesFile(t *testing.T, filePath string, gzipped bool) []*Event {
+	t.Helper()
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if gzipped {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gzipReader
+	}
+	events, err := ReadJSONLines(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// junitSuiteName is the name of the JUnit testsuite and the classname
+// of its testcases.
+const junitSuiteName = "pexec"
+
+// NewJUnitSink returns a new EventSink that writes a JUnit XML report
+// to the writer when the run finishes.
+//
+// There is one testcase for each command, named by the command's name
+// if it has one, and by the command otherwise. Commands that the runner
+// killed are recorded as errors. Close does not close the writer.
+func NewJUnitSink(writer io.Writer) EventSink {
+	return newJUnitSink(writer, nil)
+}
+
+// CreateJUnitSink returns a new EventSink that creates or truncates
+// the file at filePath and writes a JUnit XML report to it when the
+// run finishes.
+//
+// The file is closed by Close.
+func CreateJUnitSink(filePath string) (EventSink, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return newJUnitSink(file, file), nil
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name          `xml:"testsuites"`
+	TestSuites []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      string           `xml:"time,attr"`
+	Timestamp string           `xml:"timestamp,attr,omitempty"`
+	TestCases []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+
+	id int
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitSink struct {
+	writer       io.Writer
+	closer       io.Closer
+	startTime    time.Time
+	testCases    map[int]*junitTestCase
+	killedCmdIDs map[int]struct{}
+	err          error
+	lock         sync.Mutex
+}
+
+func newJUnitSink(writer io.Writer, closer io.Closer) *junitSink {
+	return &junitSink{
+		writer:       writer,
+		closer:       closer,
+		testCases:    make(map[int]*junitTestCase),
+		killedCmdIDs: make(map[int]struct{}),
+	}
+}
+
+func (s *junitSink) Handle(event *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch event.Type {
+	case EventTypeStarted:
+		s.startTime = event.Time
+	case EventTypeCmdKilled:
+		s.killedCmdIDs[event.CmdID] = struct{}{}
+	case EventTypeCmdFinished:
+		testCase := s.testCase(event)
+		testCase.Time = junitSeconds(event.Duration)
+		testCase.SystemOut = event.Stdout
+		testCase.SystemErr = event.Stderr
+		_, killed := s.killedCmdIDs[event.CmdID]
+		switch {
+		case event.Cancelled:
+			testCase.Skipped = &junitSkipped{Message: skipReasonCancelled}
+		case event.Error != "":
+			testCase.Failure = &junitFailure{Message: event.Error, Type: failureType(event), Text: event.Error}
+		case killed || event.Signal != "":
+			// commands killed at the end of the run have no error
+			testCase.Error = &junitFailure{Message: skipReasonKilled, Type: failureType(event)}
+		}
+	case EventTypeCmdSkipped:
+		s.testCase(event).Skipped = &junitSkipped{Message: event.Reason}
+	case EventTypeFinished:
+		if s.err == nil {
+			s.err = s.write(event)
+		}
+	}
+}
+
+func (s *junitSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.err
+	if s.closer != nil {
+		if closeErr := s.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// testCase returns the testcase for the command event, creating it
+// if needed.
+//
+// Must be called with the lock held.
+func (s *junitSink) testCase(event *Event) *junitTestCase {
+	testCase, ok := s.testCases[event.CmdID]
+	if !ok {
+		name := event.Name
+		if name == "" {
+			name = event.Cmd
+		}
+		testCase = &junitTestCase{Name: name, ClassName: junitSuiteName, Time: junitSeconds(0), id: event.CmdID}
+		s.testCases[event.CmdID] = testCase
+	}
+	return testCase
+}
+
+// write writes the report for the EventTypeFinished event.
+//
+// Must be called with the lock held.
+func (s *junitSink) write(event *Event) error {
+	testSuite := &junitTestSuite{
+		Name:  junitSuiteName,
+		Tests: len(s.testCases),
+		Time:  junitSeconds(event.Duration),
+	}
+	if !s.startTime.IsZero() {
+		testSuite.Timestamp = s.startTime.UTC().Format("2006-01-02T15:04:05")
+	}
+	for _, testCase := range s.testCases {
+		testSuite.TestCases = append(testSuite.TestCases, testCase)
+		switch {
+		case testCase.Failure != nil:
+			testSuite.Failures++
+		case testCase.Error != nil:
+			testSuite.Errors++
+		case testCase.Skipped != nil:
+			testSuite.Skipped++
+		}
+	}
+	sort.Slice(testSuite.TestCases, func(i, j int) bool {
+		return testSuite.TestCases[i].id < testSuite.TestCases[j].id
+	})
+
+	if _, err := io.WriteString(s.writer, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(s.writer)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(&junitTestSuites{TestSuites: []*junitTestSuite{testSuite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(s.writer, "\n")
+	return err
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
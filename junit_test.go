@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJUnitSink(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	sink := NewJUnitSink(buffer)
+	for _, event := range testRunEvents() {
+		sink.Handle(event)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="pexec" tests="5" failures="1" errors="1" skipped="2" time="3.000" timestamp="2021-04-01T00:00:00">
+    <testcase name="foo" classname="pexec" time="3.000"></testcase>
+    <testcase name="bar" classname="pexec" time="1.000">
+      <failure message="command had error: bar" type="exit code 2">command had error: bar</failure>
+      <system-out>bar out&#xA;</system-out>
+      <system-err>bar err&#xA;</system-err>
+    </testcase>
+    <testcase name="baz" classname="pexec" time="0.000">
+      <skipped message="fast_fail"></skipped>
+    </testcase>
+    <testcase name="qux" classname="pexec" time="2.000">
+      <error message="killed" type="signal: killed"></error>
+    </testcase>
+    <testcase name="quux" classname="pexec" time="1.000">
+      <skipped message="cancelled"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	if diff := cmp.Diff(want, buffer.String()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package metrics exposes the events of a pexec Runner as Prometheus
+// metrics.
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+// DefaultNamespace is the default namespace of the metrics.
+const DefaultNamespace = "pexec"
+
+// DefaultDurationBuckets are the default buckets of the command
+// duration histogram in seconds.
+var DefaultDurationBuckets = prometheus.ExponentialBuckets(0.01, 4, 10)
+
+// Option is an option for new Metrics.
+type Option func(*options)
+
+type options struct {
+	Namespace       string
+	DurationBuckets []float64
+}
+
+// WithNamespace returns an Option that will prefix the metric names
+// with the given namespace instead of DefaultNamespace.
+func WithNamespace(namespace string) Option {
+	return func(options *options) {
+		options.Namespace = namespace
+	}
+}
+
+// WithDurationBuckets returns an Option that will use the given
+// buckets in seconds for the command duration histogram instead of
+// DefaultDurationBuckets.
+func WithDurationBuckets(durationBuckets []float64) Option {
+	return func(options *options) {
+		options.DurationBuckets = durationBuckets
+	}
+}
+
+// Metrics are Prometheus metrics for the events of a pexec Runner.
+//
+// Metrics is a prometheus.Collector, and Handle should be given to
+// pexec.WithEventSubscriber. The gauges reflect the latest run, so
+// the same Metrics should not be used by runs that overlap.
+type Metrics struct {
+	cmdsStarted  prometheus.Counter
+	cmdsFinished prometheus.Counter
+	cmdsFailed   prometheus.Counter
+	cmdDuration  prometheus.Histogram
+	cmdsQueued   prometheus.Gauge
+	cmdsRunning  prometheus.Gauge
+	freeSlots    prometheus.Gauge
+
+	maxConcurrentCmds int
+	queuedCmdIDs      map[int]struct{}
+	runningCmdIDs     map[int]struct{}
+	lock              sync.Mutex
+}
+
+// New returns new Metrics.
+func New(opts ...Option) *Metrics {
+	options := &options{DefaultNamespace, DefaultDurationBuckets}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return &Metrics{
+		cmdsStarted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Name:      "cmds_started_total",
+			Help:      "Number of command attempts started.",
+		}),
+		cmdsFinished: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Name:      "cmds_finished_total",
+			Help:      "Number of commands finished.",
+		}),
+		cmdsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Name:      "cmds_failed_total",
+			Help:      "Number of commands finished with an error.",
+		}),
+		cmdDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: options.Namespace,
+			Name:      "cmd_duration_seconds",
+			Help:      "Duration of the last attempt of finished commands.",
+			Buckets:   options.DurationBuckets,
+		}),
+		cmdsQueued: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: options.Namespace,
+			Name:      "cmds_queued",
+			Help:      "Number of commands waiting for a slot.",
+		}),
+		cmdsRunning: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: options.Namespace,
+			Name:      "cmds_running",
+			Help:      "Number of commands running.",
+		}),
+		freeSlots: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: options.Namespace,
+			Name:      "free_slots",
+			Help:      "Number of free slots to run commands in, or -1 if unlimited.",
+		}),
+		queuedCmdIDs:  make(map[int]struct{}),
+		runningCmdIDs: make(map[int]struct{}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(descs chan<- *prometheus.Desc) {
+	for _, collector := range m.collectors() {
+		collector.Describe(descs)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(metrics chan<- prometheus.Metric) {
+	for _, collector := range m.collectors() {
+		collector.Collect(metrics)
+	}
+}
+
+// Handle updates the metrics for the event.
+func (m *Metrics) Handle(event *pexec.Event) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	switch event.Type {
+	case pexec.EventTypeStarted:
+		m.maxConcurrentCmds = event.MaxConcurrentCmds
+		m.queuedCmdIDs = make(map[int]struct{})
+		m.runningCmdIDs = make(map[int]struct{})
+	case pexec.EventTypeCmdQueued:
+		m.queuedCmdIDs[event.CmdID] = struct{}{}
+	case pexec.EventTypeCmdStarted:
+		m.cmdsStarted.Inc()
+		delete(m.queuedCmdIDs, event.CmdID)
+		m.runningCmdIDs[event.CmdID] = struct{}{}
+	case pexec.EventTypeCmdSkipped:
+		delete(m.queuedCmdIDs, event.CmdID)
+	case pexec.EventTypeCmdFinished:
+		m.cmdsFinished.Inc()
+		if event.Error != "" {
+			m.cmdsFailed.Inc()
+		}
+		m.cmdDuration.Observe(event.Duration.Seconds())
+		delete(m.runningCmdIDs, event.CmdID)
+	case pexec.EventTypeFinished:
+		m.queuedCmdIDs = make(map[int]struct{})
+		m.runningCmdIDs = make(map[int]struct{})
+	}
+	m.cmdsQueued.Set(float64(len(m.queuedCmdIDs)))
+	m.cmdsRunning.Set(float64(len(m.runningCmdIDs)))
+	if m.maxConcurrentCmds > 0 {
+		m.freeSlots.Set(float64(m.maxConcurrentCmds - len(m.runningCmdIDs)))
+	} else {
+		m.freeSlots.Set(-1)
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.cmdsStarted,
+		m.cmdsFinished,
+		m.cmdsFailed,
+		m.cmdDuration,
+		m.cmdsQueued,
+		m.cmdsRunning,
+		m.freeSlots,
+	}
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	exec "golang.org/x/sys/execabs"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := New()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+	server := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer server.Close()
+
+	ctx := context.Background()
+	err := pexec.NewRunner(
+		pexec.WithMaxConcurrentCmds(2),
+		pexec.WithEventHandler(func(*pexec.Event) {}),
+		pexec.WithEventSubscriber(metrics.Handle),
+	).Run(pexec.ExecCmds(ctx, []*exec.Cmd{
+		exec.Command("true"),
+		exec.Command("true"),
+		exec.Command("false"),
+	}))
+	if err == nil {
+		t.Fatal("except err is non-nil")
+	}
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"pexec_cmds_started_total 3",
+		"pexec_cmds_finished_total 3",
+		"pexec_cmds_failed_total 1",
+		"pexec_cmd_duration_seconds_count 3",
+		"pexec_cmds_queued 0",
+		"pexec_cmds_running 0",
+		"pexec_free_slots 2",
+	} {
+		if !strings.Contains(string(data), want+"\n") {
+			t.Fatalf("except %q in scrape:\n%s", want, string(data))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import "sync"
+
+// tailBuffer is an io.Writer that keeps the last limit bytes
+// written to it.
+type tailBuffer struct {
+	limit     int
+	data      []byte
+	truncated bool
+	lock      sync.Mutex
+}
+
+func newTailBuffer(limit int) *tailBuffer {
+	return &tailBuffer{limit: limit}
+}
+
+func (b *tailBuffer) Write(data []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n := len(data)
+	if len(data) > b.limit {
+		data = data[len(data)-b.limit:]
+		b.truncated = true
+	}
+	b.data = append(b.data, data...)
+	// compact once the buffer is twice the limit so that the
+	// copying is amortized
+	if len(b.data) > 2*b.limit {
+		b.data = append(b.data[:0], b.data[len(b.data)-b.limit:]...)
+		b.truncated = true
+	}
+	return n, nil
+}
+
+// Tail returns a copy of the last limit bytes written, and whether
+// any bytes before them were dropped.
+func (b *tailBuffer) Tail() ([]byte, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	data := b.data
+	truncated := b.truncated
+	if len(data) > b.limit {
+		data = data[len(data)-b.limit:]
+		truncated = true
+	}
+	return append([]byte(nil), data...), truncated
+}
+
+// Reset drops all bytes written.
+func (b *tailBuffer) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.data = nil
+	b.truncated = false
+}
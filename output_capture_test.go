@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTailBuffer(t *testing.T) {
+	buffer := newTailBuffer(4)
+	for _, data := range []string{"ab", "cd", "ef", "gh", "ijkl", "m"} {
+		if _, err := buffer.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tail, truncated := buffer.Tail()
+	if diff := cmp.Diff("jklm", string(tail)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if !truncated {
+		t.Fatal("except truncated")
+	}
+
+	buffer.Reset()
+	if _, err := buffer.Write([]byte("xy")); err != nil {
+		t.Fatal(err)
+	}
+	tail, truncated = buffer.Tail()
+	if diff := cmp.Diff("xy", string(tail)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if truncated {
+		t.Fatal("except not truncated")
+	}
+}
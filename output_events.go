@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+type outputEventConfig struct {
+	MaxLineLength  int
+	LinesPerSecond float64
+	Burst          int
+}
+
+func newOutputEventConfig(options ...OutputEventOption) *outputEventConfig {
+	config := &outputEventConfig{DefaultOutputEventMaxLineLength, 0, 0}
+	for _, option := range options {
+		option(config)
+	}
+	if config.MaxLineLength <= 0 {
+		config.MaxLineLength = DefaultOutputEventMaxLineLength
+	}
+	if config.Burst <= 0 {
+		config.Burst = 1
+	}
+	return config
+}
+
+// lineWriter is an io.Writer that calls handleLine with each line
+// written to it, cut to the maximum line length.
+type lineWriter struct {
+	stream        string
+	maxLineLength int
+	handleLine    func(stream string, line string)
+	buffer        []byte
+	// discarding says that the rest of the current line is discarded
+	// because it is longer than the maximum line length
+	discarding bool
+	lock       sync.Mutex
+}
+
+func newLineWriter(stream string, maxLineLength int, handleLine func(string, string)) *lineWriter {
+	return &lineWriter{stream: stream, maxLineLength: maxLineLength, handleLine: handleLine}
+}
+
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	n := len(data)
+	for len(data) > 0 {
+		index := bytes.IndexByte(data, '\n')
+		chunk := data
+		if index >= 0 {
+			chunk = data[:index]
+			data = data[index+1:]
+		} else {
+			data = nil
+		}
+		if !w.discarding {
+			if room := w.maxLineLength - len(w.buffer); len(chunk) > room {
+				chunk = chunk[:room]
+				w.discarding = true
+			}
+			w.buffer = append(w.buffer, chunk...)
+		}
+		if index >= 0 {
+			w.handleLine(w.stream, string(w.buffer))
+			w.buffer = w.buffer[:0]
+			w.discarding = false
+		}
+	}
+	return n, nil
+}
+
+// Flush handles the last line if it did not end with a newline.
+func (w *lineWriter) Flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.buffer) > 0 || w.discarding {
+		w.handleLine(w.stream, string(w.buffer))
+	}
+	w.buffer = w.buffer[:0]
+	w.discarding = false
+}
+
+// rateLimiter is a token bucket.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Allow returns true if an event at time t is within the rate limit.
+func (l *rateLimiter) Allow(t time.Time) bool {
+	if !l.last.IsZero() {
+		l.tokens += t.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = t
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,282 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GroupOption is an option for an OutputGroup.
+type GroupOption func(*outputGroup)
+
+// WithGroupKeepOrder returns a GroupOption that will make the
+// OutputGroup write the output of the commands in the order of their
+// IDs rather than the order in which they finish.
+func WithGroupKeepOrder() GroupOption {
+	return func(group *outputGroup) {
+		group.keepOrder = true
+	}
+}
+
+// WithGroupMemoryLimit returns a GroupOption that will make the
+// OutputGroup spill the output of each stream of a command to a
+// temporary file once it exceeds the limit in bytes.
+func WithGroupMemoryLimit(limit int) GroupOption {
+	return func(group *outputGroup) {
+		group.memoryLimit = limit
+	}
+}
+
+// WithGroupTempDir returns a GroupOption that will make the
+// OutputGroup create its temporary files in the directory.
+func WithGroupTempDir(dir string) GroupOption {
+	return func(group *outputGroup) {
+		group.tempDir = dir
+	}
+}
+
+// OutputGroup is an EventSink that buffers the output of each command
+// and writes it as one block, after a header naming the command and its
+// result, when the command finishes or is skipped.
+//
+// The stdout and stderr of each command should be set to the writers
+// returned by Writers before the commands are run.
+type OutputGroup interface {
+	EventSink
+	// Writers returns the stdout and stderr writers for the command
+	// with the ID.
+	Writers(id int) (io.Writer, io.Writer)
+}
+
+// NewOutputGroup returns a new OutputGroup that writes the header and
+// stdout of each command to stdout, and its stderr to stderr.
+//
+// Close writes the output of the commands that have not finished, and
+// does not close the writers.
+func NewOutputGroup(stdout io.Writer, stderr io.Writer, options ...GroupOption) OutputGroup {
+	group := &outputGroup{
+		stdout:      stdout,
+		stderr:      stderr,
+		memoryLimit: DefaultGroupMemoryLimit,
+		cmds:        make(map[int]*groupCmd),
+	}
+	for _, option := range options {
+		option(group)
+	}
+	return group
+}
+
+type outputGroup struct {
+	stdout      io.Writer
+	stderr      io.Writer
+	keepOrder   bool
+	memoryLimit int
+	tempDir     string
+	cmds        map[int]*groupCmd
+	nextID      int
+	err         error
+	lock        sync.Mutex
+}
+
+type groupCmd struct {
+	Stdout *groupBuffer
+	Stderr *groupBuffer
+	Header string
+	Done   bool
+	Killed bool
+}
+
+func (g *outputGroup) Writers(id int) (io.Writer, io.Writer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	cmd := g.cmd(id)
+	return cmd.Stdout, cmd.Stderr
+}
+
+func (g *outputGroup) Handle(event *Event) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	switch event.Type {
+	case EventTypeCmdKilled:
+		g.cmd(event.CmdID).Killed = !event.Cancelled
+	case EventTypeCmdFinished, EventTypeCmdSkipped:
+		cmd := g.cmd(event.CmdID)
+		cmd.Header = groupHeader(event, cmd.Killed)
+		cmd.Done = true
+		if !g.keepOrder {
+			g.write(event.CmdID)
+			return
+		}
+		for {
+			cmd, ok := g.cmds[g.nextID]
+			if !ok || !cmd.Done {
+				return
+			}
+			g.write(g.nextID)
+			g.nextID++
+		}
+	case EventTypeFinished:
+		g.writeAll()
+	}
+}
+
+func (g *outputGroup) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.writeAll()
+	return g.err
+}
+
+// cmd returns the command with the ID, creating it if needed.
+//
+// Must be called with the lock held.
+func (g *outputGroup) cmd(id int) *groupCmd {
+	cmd, ok := g.cmds[id]
+	if !ok {
+		cmd = &groupCmd{
+			Stdout: newGroupBuffer(g.memoryLimit, g.tempDir),
+			Stderr: newGroupBuffer(g.memoryLimit, g.tempDir),
+		}
+		g.cmds[id] = cmd
+	}
+	return cmd
+}
+
+// writeAll writes the output of all remaining commands in the order
+// of their IDs.
+//
+// Must be called with the lock held.
+func (g *outputGroup) writeAll() {
+	ids := make([]int, 0, len(g.cmds))
+	for id := range g.cmds {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		g.write(id)
+	}
+}
+
+// write writes the output of the command with the ID and releases it.
+//
+// Must be called with the lock held.
+func (g *outputGroup) write(id int) {
+	cmd := g.cmds[id]
+	delete(g.cmds, id)
+	header := cmd.Header
+	if header == "" {
+		header = fmt.Sprintf("=== [%d] unfinished\n", id)
+	}
+	err := errors.Join(
+		writeString(g.stdout, header),
+		cmd.Stdout.Flush(g.stdout),
+		cmd.Stderr.Flush(g.stderr),
+	)
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// groupHeader returns the header for the EventTypeCmdFinished or
+// EventTypeCmdSkipped event, and killed says that the runner killed the
+// command.
+func groupHeader(event *Event, killed bool) string {
+	tag := event.Name
+	if tag == "" {
+		tag = strconv.Itoa(event.CmdID)
+	}
+	var result string
+	switch {
+	case event.Type == EventTypeCmdSkipped:
+		result = "skipped: " + event.Reason
+	case event.Cancelled:
+		result = "cancelled after " + groupDuration(event.Duration)
+	case killed || event.Error == "" && event.Signal != "":
+		result = "killed after " + groupDuration(event.Duration)
+	case event.Error != "":
+		result = "failed (" + failureType(event) + ") in " + groupDuration(event.Duration)
+	default:
+		result = "succeeded in " + groupDuration(event.Duration)
+	}
+	return fmt.Sprintf("=== [%s] %s: %s\n", tag, event.Cmd, result)
+}
+
+// groupDuration formats a duration in a group header as seconds with
+// millisecond precision.
+func groupDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+func writeString(writer io.Writer, s string) error {
+	_, err := io.WriteString(writer, s)
+	return err
+}
+
+// groupBuffer is the buffered output of one stream of a command, which
+// is spilled to a temporary file once it exceeds the memory limit.
+type groupBuffer struct {
+	memoryLimit int
+	tempDir     string
+	memory      []byte
+	file        *os.File
+	err         error
+	lock        sync.Mutex
+}
+
+func newGroupBuffer(memoryLimit int, tempDir string) *groupBuffer {
+	return &groupBuffer{memoryLimit: memoryLimit, tempDir: tempDir}
+}
+
+func (b *groupBuffer) Write(data []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.file == nil && len(b.memory)+len(data) <= b.memoryLimit {
+		b.memory = append(b.memory, data...)
+		return len(data), nil
+	}
+	if b.file == nil {
+		b.file, b.err = os.CreateTemp(b.tempDir, "pexec-group-*")
+		if b.err != nil {
+			return 0, b.err
+		}
+	}
+	n, err := b.file.Write(data)
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Flush writes the buffered output to the writer, and removes the
+// temporary file if any.
+func (b *groupBuffer) Flush(writer io.Writer) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	err := b.err
+	if len(b.memory) > 0 && err == nil {
+		_, err = writer.Write(b.memory)
+	}
+	b.memory = nil
+	if b.file == nil {
+		return err
+	}
+	if err == nil {
+		if _, err = b.file.Seek(0, io.SeekStart); err == nil {
+			_, err = io.Copy(writer, b.file)
+		}
+	}
+	err = errors.Join(err, b.file.Close(), os.Remove(b.file.Name()))
+	b.file = nil
+	return err
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOutputGroup(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		options    []GroupOption
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name: "completion order",
+			wantStdout: "=== [1] bar: failed (exit code 2) in 1.500s\nbar out\n" +
+				"=== [0] foo: succeeded in 3.000s\nfoo out that spills\n" +
+				"=== [2] baz: skipped: fast_fail\n" +
+				"=== [3] qux: killed after 2.000s\n",
+			wantStderr: "bar err\nfoo err\n",
+		},
+		{
+			name:    "keep order",
+			options: []GroupOption{WithGroupKeepOrder()},
+			wantStdout: "=== [0] foo: succeeded in 3.000s\nfoo out that spills\n" +
+				"=== [1] bar: failed (exit code 2) in 1.500s\nbar out\n" +
+				"=== [2] baz: skipped: fast_fail\n" +
+				"=== [3] qux: killed after 2.000s\n",
+			wantStderr: "foo err\nbar err\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+			exitCode := 2
+			failedEvent := newCmdFinishedEvent(startTime.Add(2500*time.Millisecond), 1, testCmd("bar"), startTime.Add(time.Second), errors.New("command had error"))
+			failedEvent.ExitCode = &exitCode
+
+			tempDir := t.TempDir()
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			group := NewOutputGroup(stdout, stderr, append(test.options, WithGroupMemoryLimit(8), WithGroupTempDir(tempDir))...)
+			fooStdout, fooStderr := group.Writers(0)
+			barStdout, barStderr := group.Writers(1)
+			for _, write := range []struct {
+				writer io.Writer
+				data   string
+			}{
+				{fooStdout, "foo out"},
+				{barStdout, "bar out\n"},
+				{barStderr, "bar err\n"},
+				{fooStdout, " that spills\n"},
+				{fooStderr, "foo err\n"},
+			} {
+				if _, err := write.writer.Write([]byte(write.data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, event := range []*Event{
+				newStartedEvent(startTime, 4, 0),
+				failedEvent,
+				newCmdFinishedEvent(startTime.Add(3*time.Second), 0, testCmd("foo"), startTime, nil),
+				newCmdSkippedEvent(startTime.Add(3*time.Second), 2, testCmd("baz"), skipReasonFastFail, nil),
+				newCmdKilledEvent(startTime.Add(3*time.Second), 3, testCmd("qux"), false, nil),
+				newCmdStoppedEvent(startTime.Add(3*time.Second), 3, testCmd("qux"), startTime.Add(time.Second), false, nil),
+				newFinishedEvent(startTime.Add(3*time.Second), startTime, errCmdFailed),
+			} {
+				group.Handle(event)
+			}
+			if err := group.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.wantStdout, stdout.String()); diff != "" {
+				t.Fatalf("(-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantStderr, stderr.String()); diff != "" {
+				t.Fatalf("(-want +got):\n%s", diff)
+			}
+			entries, err := os.ReadDir(tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Fatalf("expected temporary files to be removed but got %d", len(entries))
+			}
+		})
+	}
+}
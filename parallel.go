@@ -6,6 +6,7 @@ package pexec
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"runtime"
 	"time"
@@ -14,25 +15,156 @@ import (
 	exec "golang.org/x/sys/execabs"
 )
 
-// DefaultFastFail is the default value for fast fail.
-const DefaultFastFail = false
+const (
+	// DefaultFastFail is the default value for fast fail.
+	DefaultFastFail = false
+	// DefaultRetries is the default number of retries.
+	DefaultRetries = 0
+)
 
 var (
 	// DefaultMaxConcurrentCmds is the default value for the maximum
 	// number of concurrent commands.
 	DefaultMaxConcurrentCmds = runtime.NumCPU()
-	// DefaultEventHandler is the default Event handler.
+	// DefaultEventHandler is the default Event handler, which logs
+	// each event as JSON with the log package.
+	//
+	// Set it to the handler returned by NewSlogEventHandler to log
+	// events with log/slog instead.
 	DefaultEventHandler = logEvent
 	// DefaultClock is the default function to use as a clock.
 	DefaultClock = time.Now
+	// DefaultSubscriberBufferSize is the default number of events
+	// buffered for each event subscriber.
+	DefaultSubscriberBufferSize = 1024
+	// DefaultSubscriberOverflowPolicy is the default OverflowPolicy
+	// for each event subscriber.
+	DefaultSubscriberOverflowPolicy = OverflowPolicyBlock
+	// DefaultOutputEventMaxLineLength is the default maximum length
+	// in bytes of the line of an EventTypeCmdOutput event.
+	DefaultOutputEventMaxLineLength = 4096
+	// DefaultProgressWidth is the default width in columns of the
+	// terminal of a ProgressView.
+	DefaultProgressWidth = 80
+	// DefaultProgressInterval is the default interval at which a
+	// ProgressView is redrawn.
+	DefaultProgressInterval = 100 * time.Millisecond
+	// DefaultSummarySlowest is the default number of slowest commands
+	// in a Summary.
+	DefaultSummarySlowest = 5
+	// DefaultSummaryOutputLines is the default number of lines of
+	// output of each failed command in a Summary.
+	DefaultSummaryOutputLines = 10
+	// DefaultGroupMemoryLimit is the default number of bytes of each
+	// stream of a command an OutputGroup keeps in memory.
+	DefaultGroupMemoryLimit = 1 << 20
+	// DefaultFanOutBufferSize is the default number of bytes FanOut
+	// buffers for each reader.
+	DefaultFanOutBufferSize = 1 << 20
+	// DefaultWaitDelay is the WaitDelay set on the exec.Cmd of an
+	// ExecCmd that does not set one, which is how long Wait waits for
+	// the output of the command to be closed after it exits, for
+	// example by its own children. A command that exits successfully
+	// still succeeds once the delay expires. A PTYCmd waits as long
+	// for the output of its PTY.
+	DefaultWaitDelay = time.Second
+)
+
+const (
+	// OverflowPolicyBlock blocks the runner until the subscriber
+	// has room for the event.
+	OverflowPolicyBlock OverflowPolicy = iota + 1
+	// OverflowPolicyDrop drops command events, and reports the number
+	// of dropped events in the EventTypeFinished event.
+	OverflowPolicyDrop
+	// OverflowPolicyCoalesce replaces the newest buffered event of the
+	// same type for the same command, and blocks if there is none.
+	OverflowPolicyCoalesce
 )
 
+// OverflowPolicy says what happens when an event is published to a
+// subscriber whose buffer is full.
+//
+// Events of type EventTypeStarted and EventTypeFinished are never
+// dropped or coalesced.
+type OverflowPolicy int
+
 // Event is an event that happens during the runner's Run call.
+//
+// The command fields are only set for command events, and the process
+// fields are only set once a command's process has exited.
+//
+// Events are encoded to JSON and YAML with all fields other than Type,
+// Time and Error nested under "fields".
 type Event struct {
-	Type   EventType              `json:"type,omitempty" yaml:"type,omitempty"`
-	Time   time.Time              `json:"time,omitempty" yaml:"time,omitempty"`
-	Fields map[string]interface{} `json:"fields,omitempty" yaml:"fields,omitempty"`
-	Error  string                 `json:"error,omitempty" yaml:"error,omitempty"`
+	Type  EventType
+	Time  time.Time
+	Error string
+
+	// NumCmds is the number of commands on EventTypeStarted events.
+	NumCmds int
+	// MaxConcurrentCmds is the maximum number of concurrent commands,
+	// or 0 if unlimited, on EventTypeStarted events.
+	MaxConcurrentCmds int
+
+	// CmdID is the ID of the command, which is its index in the
+	// commands given to the Runner.
+	CmdID int
+	// Cmd is the string representation of the command.
+	Cmd string
+	// Name is the name of the command if it is a NamedCmd.
+	Name string
+	// Attempt is the attempt number of the command, starting at 1,
+	// or 0 if unknown.
+	Attempt int
+	// Duration is the duration of the command or the run for
+	// finished events.
+	Duration time.Duration
+	// Cancelled says that the command was cancelled.
+	Cancelled bool
+	// Reason is the reason a command was skipped.
+	Reason string
+
+	// PID is the process ID of the command.
+	PID int
+	// ExitCode is the exit code of the command, or -1 if it was
+	// terminated by a signal. It is nil if the process did not exit.
+	ExitCode *int
+	// Signal is the signal that terminated the command.
+	Signal string
+	// SignalNumber is the number of the signal that terminated the command.
+	SignalNumber int
+	// UserTime is the user CPU time of the command.
+	UserTime time.Duration
+	// SystemTime is the system CPU time of the command.
+	SystemTime time.Duration
+	// MaxRSS is the maximum resident set size of the command in bytes.
+	MaxRSS int64
+
+	// Stdout is the captured tail of the stdout of the command on
+	// failed EventTypeCmdFinished events if WithFailureOutput is set.
+	Stdout string
+	// Stderr is the captured tail of the stderr of the command on
+	// failed EventTypeCmdFinished events if WithFailureOutput is set.
+	Stderr string
+
+	// Stream is the stream a line of output was written to, either
+	// "stdout" or "stderr", on EventTypeCmdOutput events.
+	Stream string
+	// Line is the line of output without the newline, cut to the
+	// maximum line length, on EventTypeCmdOutput events.
+	Line string
+	// DroppedLines is the number of lines of output of the command
+	// dropped by the rate limit since the last EventTypeCmdOutput event,
+	// on EventTypeCmdOutput and EventTypeCmdFinished events.
+	DroppedLines int
+
+	// DroppedEvents is the number of events dropped for the subscriber
+	// on EventTypeFinished events.
+	DroppedEvents int
+
+	// Fields are any additional fields.
+	Fields map[string]interface{}
 }
 
 // RunnerOption is an option for a new Runner.
@@ -54,14 +186,150 @@ func WithMaxConcurrentCmds(maxConcurrentCmds int) RunnerOption {
 	}
 }
 
+// WithRetries returns a RunnerOption that will make the Runner
+// retry each failed command up to retries times if it is a
+// RetryableCmd.
+func WithRetries(retries int) RunnerOption {
+	return func(runner *runner) {
+		runner.Retries = retries
+	}
+}
+
+// WithOutputCapture returns a RunnerOption that will make the Runner
+// capture the stdout and stderr of each command that is an OutputCmd,
+// keeping the last limit bytes of each, for Handle's Results.
+//
+// The output of a command is captured in addition to being written
+// to its own stdout and stderr, and only for its last attempt. A
+// CaptureCmd can override the limit for itself.
+func WithOutputCapture(limit int) RunnerOption {
+	return func(runner *runner) {
+		runner.OutputLimit = limit
+	}
+}
+
+// WithFailureOutput returns a RunnerOption that will make the Runner
+// set the captured output of failed commands on their
+// EventTypeCmdFinished events.
+//
+// This has no effect unless WithOutputCapture is also set.
+func WithFailureOutput() RunnerOption {
+	return func(runner *runner) {
+		runner.FailureOutput = true
+	}
+}
+
+// WithRedactor returns a RunnerOption that will make the Runner redact
+// the string fields of every Event before it reaches an event hook or
+// subscriber, and the captured output in Handle's Results.
+func WithRedactor(redactor Redactor) RunnerOption {
+	return func(runner *runner) {
+		runner.Redactor = redactor
+	}
+}
+
+// WithOutputEvents returns a RunnerOption that will make the Runner
+// emit an EventTypeCmdOutput event for each line each command that is
+// an OutputCmd writes to its stdout or stderr.
+func WithOutputEvents(options ...OutputEventOption) RunnerOption {
+	return func(runner *runner) {
+		runner.OutputEvents = newOutputEventConfig(options...)
+	}
+}
+
+// WithSkip returns a RunnerOption that will not run the commands for
+// which skip returns a non-empty reason, and will emit an
+// EventTypeCmdSkipped event with the reason for each of them instead.
+func WithSkip(skip func(id int, cmd Cmd) string) RunnerOption {
+	return func(runner *runner) {
+		runner.Skip = skip
+	}
+}
+
 // WithEventHandler returns a RunnerOption that will use the
-// given EventHandler.
+// given EventHandler instead of DefaultEventHandler.
+//
+// The EventHandler is a subscriber with the default SubscriberOptions.
 func WithEventHandler(eventHandler func(*Event)) RunnerOption {
 	return func(runner *runner) {
 		runner.EventHandler = eventHandler
 	}
 }
 
+// WithEventSubscriber returns a RunnerOption that will deliver
+// events to the given handler in addition to the EventHandler and
+// any other subscribers.
+//
+// Each subscriber receives events in order on its own goroutine,
+// and all events are delivered before Run returns. Events are shared
+// between subscribers and must not be modified.
+func WithEventSubscriber(handler func(*Event), options ...SubscriberOption) RunnerOption {
+	return func(runner *runner) {
+		runner.Subscribers = append(runner.Subscribers, newSubscriberConfig(handler, options...))
+	}
+}
+
+// WithEventHook returns a RunnerOption that will call hook
+// synchronously for each event before it is delivered to the
+// subscribers, together with the Cmd the event is for, or nil for
+// EventTypeStarted and EventTypeFinished events.
+//
+// The hook is called for EventTypeCmdStarted events before the Cmd is
+// started, so it can modify the Cmd. The hook is called while the
+// runner holds the lock of the command, except for EventTypeCmdOutput
+// events, so it must not block, for example on I/O, and must not modify
+// the event. Use
+// WithEventSubscriber for handlers that can be slow.
+func WithEventHook(hook func(event *Event, cmd Cmd)) RunnerOption {
+	return func(runner *runner) {
+		runner.EventHooks = append(runner.EventHooks, hook)
+	}
+}
+
+// SubscriberOption is an option for an event subscriber.
+type SubscriberOption func(*subscriberConfig)
+
+// WithSubscriberBufferSize returns a SubscriberOption that will
+// buffer up to bufferSize events for the subscriber.
+func WithSubscriberBufferSize(bufferSize int) SubscriberOption {
+	return func(config *subscriberConfig) {
+		config.BufferSize = bufferSize
+	}
+}
+
+// WithSubscriberOverflowPolicy returns a SubscriberOption that will
+// use the given OverflowPolicy when the subscriber's buffer is full.
+func WithSubscriberOverflowPolicy(overflowPolicy OverflowPolicy) SubscriberOption {
+	return func(config *subscriberConfig) {
+		config.OverflowPolicy = overflowPolicy
+	}
+}
+
+// OutputEventOption is an option for EventTypeCmdOutput events.
+type OutputEventOption func(*outputEventConfig)
+
+// WithOutputEventMaxLineLength returns an OutputEventOption that will
+// cut lines of output to maxLineLength bytes.
+func WithOutputEventMaxLineLength(maxLineLength int) OutputEventOption {
+	return func(config *outputEventConfig) {
+		config.MaxLineLength = maxLineLength
+	}
+}
+
+// WithOutputEventRateLimit returns an OutputEventOption that will
+// emit at most linesPerSecond events per second for each command, with
+// bursts of up to burst events, and drop the other lines.
+//
+// The number of dropped lines is reported on the next EventTypeCmdOutput
+// event of the command, or on its EventTypeCmdFinished event for the
+// lines dropped after the last one.
+func WithOutputEventRateLimit(linesPerSecond float64, burst int) OutputEventOption {
+	return func(config *outputEventConfig) {
+		config.LinesPerSecond = linesPerSecond
+		config.Burst = burst
+	}
+}
+
 // WithClock returns a RunnerOption that will make the Runner
 // use the given Clock.
 func WithClock(clock func() time.Time) RunnerOption {
@@ -82,9 +350,81 @@ type Cmd interface {
 	Kill() error
 }
 
+// RetryableCmd is a Cmd that can be run again after it fails.
+type RetryableCmd interface {
+	Cmd
+
+	// Retry returns a new Cmd to run for the next attempt.
+	Retry() (Cmd, error)
+}
+
+// EnvCmd is a Cmd whose environment can be extended before it starts.
+type EnvCmd interface {
+	Cmd
+
+	// AddEnv adds the environment variables in the form "key=value".
+	AddEnv(env ...string)
+}
+
+// InputCmd is a Cmd whose stdin can be set before it starts.
+type InputCmd interface {
+	Cmd
+
+	// SetStdin sets the stdin of the command.
+	SetStdin(stdin io.Reader)
+}
+
+// OutputCmd is a Cmd whose output can also be written elsewhere.
+type OutputCmd interface {
+	Cmd
+
+	// AddOutput makes the command also write its stdout and stderr
+	// to the given writers.
+	AddOutput(stdout io.Writer, stderr io.Writer)
+}
+
+// CaptureCmd is an OutputCmd with its own output capture limit.
+type CaptureCmd interface {
+	OutputCmd
+
+	// OutputCaptureLimit returns the number of bytes of its stdout
+	// and stderr to capture instead of the limit of WithOutputCapture,
+	// with 0 to not capture, and false to use the limit of
+	// WithOutputCapture.
+	OutputCaptureLimit() (int, bool)
+}
+
+// NamedCmd is a Cmd with a human-readable name.
+type NamedCmd interface {
+	Cmd
+
+	// Name returns the name of the command.
+	Name() string
+}
+
+// ExecCmdOption is an option for the Cmd of ExecCmd or NamedExecCmd.
+type ExecCmdOption func(*execCmd)
+
+// WithCmdOutputCapture returns an ExecCmdOption that will make the
+// Runner capture the last limit bytes of the stdout and stderr of the
+// command instead of the limit of WithOutputCapture, or not capture
+// them if limit is 0.
+func WithCmdOutputCapture(limit int) ExecCmdOption {
+	return func(cmd *execCmd) {
+		cmd.outputLimit = limit
+		cmd.hasOutputLimit = true
+	}
+}
+
 // ExecCmd returns a new Cmd for the given exec.Cmd.
-func ExecCmd(ctx context.Context, cmd *exec.Cmd) Cmd {
-	return newExecCmd(ctx, cmd)
+func ExecCmd(ctx context.Context, cmd *exec.Cmd, options ...ExecCmdOption) Cmd {
+	return newExecCmd(ctx, cmd, "", options...)
+}
+
+// NamedExecCmd returns a new NamedCmd with the given name for the
+// given exec.Cmd.
+func NamedExecCmd(ctx context.Context, name string, cmd *exec.Cmd, options ...ExecCmdOption) NamedCmd {
+	return newExecCmd(ctx, cmd, name, options...)
 }
 
 // ExecCmds returns a slice of Cmds for the given exec.Cmds.
@@ -96,6 +436,18 @@ func ExecCmds(ctx context.Context, cmds []*exec.Cmd) []Cmd {
 	return execCmds
 }
 
+// EventSink is an event handler that writes events to an output.
+//
+// Pass Handle to WithEventHandler or WithEventSubscriber, and call
+// Close once the run is complete.
+type EventSink interface {
+	// Handle the event.
+	Handle(event *Event)
+	// Close flushes and closes the output, and returns the first
+	// error encountered while writing events.
+	Close() error
+}
+
 // Runner runs the commands.
 type Runner interface {
 	// Run the commands.
@@ -105,11 +457,73 @@ type Runner interface {
 	Run(cmds []Cmd) error
 }
 
+// Starter is a Runner that can also start the commands without
+// waiting for them.
+type Starter interface {
+	Runner
+
+	// Start the commands and return a Handle to the run in progress.
+	//
+	// The IDs of the commands are their indexes in cmds.
+	Start(cmds []Cmd) Handle
+}
+
+// Handle is a handle to a run in progress.
+type Handle interface {
+	// Running returns the IDs of the commands that are currently running.
+	Running() []int
+	// Kill the command with the given ID.
+	//
+	// If the command has not started yet, it will not be started.
+	// The command is recorded as failed, and the run continues
+	// unless fast fail is set.
+	Kill(id int) error
+	// Cancel the command with the given ID.
+	//
+	// This is the same as Kill, except that the command is recorded
+	// as cancelled and does not fail the run.
+	Cancel(id int) error
+	// Wait for the run to complete.
+	//
+	// Return the same error as Runner's Run.
+	Wait() error
+	// Results returns the result of each command, indexed by ID.
+	//
+	// The results are complete once Wait has returned.
+	Results() []*CmdResult
+}
+
+// CmdResult is the result of a command.
+type CmdResult struct {
+	// ID is the ID of the command.
+	ID int
+	// Event is the EventTypeCmdFinished or EventTypeCmdSkipped event
+	// of the command, or nil if it has not finished.
+	Event *Event
+	// Stdout is the captured tail of the stdout of the command
+	// if WithOutputCapture is set.
+	Stdout []byte
+	// Stderr is the captured tail of the stderr of the command
+	// if WithOutputCapture is set.
+	Stderr []byte
+	// StdoutTruncated says that the start of Stdout was dropped to
+	// stay within the limit.
+	StdoutTruncated bool
+	// StderrTruncated says that the start of Stderr was dropped to
+	// stay within the limit.
+	StderrTruncated bool
+}
+
 // NewRunner returns a new Runner.
 func NewRunner(options ...RunnerOption) Runner {
 	return newRunner(options...)
 }
 
+// NewStarter returns a new Starter.
+func NewStarter(options ...RunnerOption) Starter {
+	return newRunner(options...)
+}
+
 func logEvent(event *Event) {
 	data, err := json.Marshal(event)
 	if err != nil {
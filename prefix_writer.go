@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPrefixLineLength is the length after which a line without a
+// newline is written anyway, so that the buffer stays bounded.
+const maxPrefixLineLength = 64 << 10
+
+// PrefixOption is an option for a prefix writer.
+type PrefixOption func(*prefixWriter)
+
+// WithPrefixColor returns a PrefixOption that will colour the prefix
+// with the ANSI SGR foreground colour code, such as 31 for red.
+func WithPrefixColor(color int) PrefixOption {
+	return func(writer *prefixWriter) {
+		writer.color = color
+	}
+}
+
+// WithPrefixTimestamp returns a PrefixOption that will put the time
+// each line was completed, formatted with the layout, before the tag.
+func WithPrefixTimestamp(layout string) PrefixOption {
+	return func(writer *prefixWriter) {
+		writer.timestampLayout = layout
+	}
+}
+
+// WithPrefixClock returns a PrefixOption that will make the writer
+// use the given clock for timestamps.
+func WithPrefixClock(clock func() time.Time) PrefixOption {
+	return func(writer *prefixWriter) {
+		writer.clock = clock
+	}
+}
+
+// NewPrefixWriter returns a new io.WriteCloser that writes each line
+// written to it to the writer, prefixed with the tag in brackets.
+//
+// Only whole lines are written, each with a single Write call, so that
+// the lines of several prefix writers sharing a writer that is safe for
+// concurrent use, such as os.Stdout, do not interleave. Close writes
+// the last line if it did not end with a newline, and does not close
+// the writer.
+func NewPrefixWriter(writer io.Writer, tag string, options ...PrefixOption) io.WriteCloser {
+	prefixWriter := &prefixWriter{
+		writer: writer,
+		tag:    tag,
+		clock:  DefaultClock,
+	}
+	for _, option := range options {
+		option(prefixWriter)
+	}
+	return prefixWriter
+}
+
+// CmdTag returns the tag for the command with the ID, which is
+// its name if it is a NamedCmd with a name, or its ID otherwise.
+func CmdTag(id int, cmd Cmd) string {
+	if name := cmdName(cmd); name != "" {
+		return name
+	}
+	return strconv.Itoa(id)
+}
+
+type prefixWriter struct {
+	writer          io.Writer
+	tag             string
+	color           int
+	timestampLayout string
+	clock           func() time.Time
+	buffer          []byte
+	err             error
+	lock            sync.Mutex
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return 0, p.err
+	}
+	p.buffer = append(p.buffer, data...)
+	var output []byte
+	for {
+		index := bytes.IndexByte(p.buffer, '\n')
+		if index < 0 {
+			if len(p.buffer) < maxPrefixLineLength {
+				break
+			}
+			index = maxPrefixLineLength - 1
+			output = p.appendLine(output, p.buffer[:index+1])
+			output = append(output, '\n')
+		} else {
+			output = p.appendLine(output, p.buffer[:index+1])
+		}
+		p.buffer = p.buffer[index+1:]
+	}
+	if len(p.buffer) == 0 {
+		p.buffer = nil
+	}
+	if len(output) > 0 {
+		if _, err := p.writer.Write(output); err != nil {
+			p.err = err
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (p *prefixWriter) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil || len(p.buffer) == 0 {
+		return p.err
+	}
+	output := append(p.appendLine(nil, p.buffer), '\n')
+	p.buffer = nil
+	if _, err := p.writer.Write(output); err != nil {
+		p.err = err
+	}
+	return p.err
+}
+
+func (p *prefixWriter) appendLine(output []byte, line []byte) []byte {
+	if p.timestampLayout != "" {
+		output = append(p.clock().AppendFormat(output, p.timestampLayout), ' ')
+	}
+	if p.color != 0 {
+		output = fmt.Appendf(output, "\x1b[%dm[%s]\x1b[0m ", p.color, p.tag)
+	} else {
+		output = fmt.Appendf(output, "[%s] ", p.tag)
+	}
+	return append(output, line...)
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	foo := NewPrefixWriter(buffer, CmdTag(0, testCmd("foo")))
+	bar := NewPrefixWriter(
+		buffer,
+		CmdTag(1, NamedExecCmd(context.Background(), "bar", nil)),
+		WithPrefixColor(31),
+		WithPrefixTimestamp("15:04:05"),
+		WithPrefixClock(func() time.Time { return time.Date(2021, 4, 1, 1, 2, 3, 0, time.UTC) }),
+	)
+	for _, write := range []struct {
+		writer io.Writer
+		data   string
+	}{
+		{foo, "hel"},
+		{bar, "one\ntw"},
+		{foo, "lo\nworld\n"},
+		{bar, "o\nthree"},
+		{foo, "!"},
+	} {
+		if _, err := write.writer.Write([]byte(write.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := foo.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bar.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "01:02:03 \x1b[31m[bar]\x1b[0m one\n" +
+		"[0] hello\n" +
+		"[0] world\n" +
+		"01:02:03 \x1b[31m[bar]\x1b[0m two\n" +
+		"[0] !\n" +
+		"01:02:03 \x1b[31m[bar]\x1b[0m three\n"
+	if diff := cmp.Diff(want, buffer.String()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,32 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import "os"
+
+// processStater is implemented by Cmds that can report the state of
+// their process after it exits.
+type processStater interface {
+	processState() *os.ProcessState
+}
+
+// setProcessState sets the exit code, signal, resource usage and PID
+// of the Cmd's process on the Event if they are available.
+func setProcessState(event *Event, cmd Cmd) {
+	stater, ok := cmd.(processStater)
+	if !ok {
+		return
+	}
+	state := stater.processState()
+	if state == nil {
+		return
+	}
+	exitCode := state.ExitCode()
+	event.PID = state.Pid()
+	event.ExitCode = &exitCode
+	event.Signal, event.SignalNumber, _ = processSignal(state)
+	event.UserTime = state.UserTime()
+	event.SystemTime = state.SystemTime()
+	event.MaxRSS, _ = processMaxRSS(state)
+}
@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !unix
+
+package pexec
+
+import "os"
+
+func processSignal(state *os.ProcessState) (string, int, bool) {
+	return "", 0, false
+}
+
+func processMaxRSS(state *os.ProcessState) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package pexec
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+func processSignal(state *os.ProcessState) (string, int, bool) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return "", 0, false
+	}
+	return status.Signal().String(), int(status.Signal()), true
+}
+
+// processMaxRSS returns the maximum resident set size in bytes.
+func processMaxRSS(state *os.ProcessState) (int64, bool) {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return 0, false
+	}
+	// ru_maxrss is in bytes on darwin and in kilobytes elsewhere
+	if runtime.GOOS == "darwin" {
+		return int64(rusage.Maxrss), true
+	}
+	return int64(rusage.Maxrss) * 1024, true
+}
@@ -0,0 +1,404 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// ProgressOption is an option for a ProgressView.
+type ProgressOption func(*progressView)
+
+// WithProgressWidth returns a ProgressOption that will cut the lines
+// of the progress view to the width of the terminal in columns.
+func WithProgressWidth(width int) ProgressOption {
+	return func(view *progressView) {
+		view.width = width
+	}
+}
+
+// WithProgressInterval returns a ProgressOption that will redraw the
+// progress view at the interval, or on each event and write if it is 0.
+func WithProgressInterval(interval time.Duration) ProgressOption {
+	return func(view *progressView) {
+		view.interval = interval
+	}
+}
+
+// WithProgressClock returns a ProgressOption that will make the
+// progress view use the given clock for elapsed times.
+func WithProgressClock(clock func() time.Time) ProgressOption {
+	return func(view *progressView) {
+		view.clock = clock
+	}
+}
+
+// ProgressView is an EventSink that draws the progress of a run on a
+// terminal, and replaces it with a summary table once the run is done.
+//
+// The progress view has a status line for each running command with its
+// elapsed time, and a line with the number of queued, running, passed,
+// failed, skipped and killed commands and an estimate of the remaining
+// time based on the durations of the finished commands.
+type ProgressView interface {
+	EventSink
+	// Writer returns a writer that writes to the output, such as the
+	// terminal or os.Stderr on the same terminal, above the progress
+	// view.
+	//
+	// Each write should be whole lines, such as those of a prefix
+	// writer. The progress view is cleared before the write and drawn
+	// again at the next interval.
+	Writer(output io.Writer) io.Writer
+}
+
+// NewProgressView returns a new ProgressView that draws on the
+// terminal with ANSI escape sequences.
+//
+// Close stops the redrawing, and does not close the terminal.
+func NewProgressView(terminal io.Writer, options ...ProgressOption) ProgressView {
+	view := &progressView{
+		terminal: terminal,
+		width:    DefaultProgressWidth,
+		interval: DefaultProgressInterval,
+		clock:    DefaultClock,
+		cmds:     make(map[int]*progressCmd),
+		doneC:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(view)
+	}
+	if view.interval > 0 {
+		view.wg.Add(1)
+		go view.redrawLoop()
+	}
+	return view
+}
+
+const (
+	progressQueued = iota + 1
+	progressRunning
+	progressPassed
+	progressFailed
+	progressSkipped
+	progressKilled
+)
+
+type progressView struct {
+	terminal          io.Writer
+	width             int
+	interval          time.Duration
+	clock             func() time.Time
+	cmds              map[int]*progressCmd
+	maxConcurrentCmds int
+	finishedDuration  time.Duration
+	numFinished       int
+	// numLines is the number of lines of the progress view currently
+	// drawn on the terminal
+	numLines  int
+	done      bool
+	err       error
+	doneC     chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	lock      sync.Mutex
+}
+
+type progressCmd struct {
+	Name      string
+	Tag       string
+	Cmd       string
+	State     int
+	Status    string
+	StartTime time.Time
+	Duration  time.Duration
+	// Killed says that the runner killed the command, which has no
+	// error if it was killed at the end of the run.
+	Killed bool
+}
+
+func (v *progressView) Handle(event *Event) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.done {
+		return
+	}
+	switch event.Type {
+	case EventTypeStarted:
+		v.maxConcurrentCmds = event.MaxConcurrentCmds
+	case EventTypeCmdQueued:
+		v.cmd(event).State = progressQueued
+	case EventTypeCmdStarted:
+		cmd := v.cmd(event)
+		cmd.State = progressRunning
+		cmd.StartTime = event.Time
+	case EventTypeCmdKilled:
+		v.cmd(event).Killed = !event.Cancelled
+		return
+	case EventTypeCmdFinished:
+		cmd := v.cmd(event)
+		cmd.Duration = event.Duration
+		switch {
+		case event.Cancelled:
+			cmd.State = progressSkipped
+			cmd.Status = "cancelled"
+		case cmd.Killed || event.Error == "" && event.Signal != "":
+			cmd.State = progressKilled
+			cmd.Status = "killed"
+		case event.Error != "":
+			cmd.State = progressFailed
+			cmd.Status = "failed (" + failureType(event) + ")"
+		default:
+			cmd.State = progressPassed
+			cmd.Status = "passed"
+		}
+		v.finishedDuration += event.Duration
+		v.numFinished++
+	case EventTypeCmdSkipped:
+		cmd := v.cmd(event)
+		cmd.State = progressSkipped
+		cmd.Status = "skipped (" + event.Reason + ")"
+	case EventTypeFinished:
+		v.clear()
+		v.done = true
+		v.writeSummary(event)
+		return
+	default:
+		return
+	}
+	if v.interval == 0 {
+		v.redraw()
+	}
+}
+
+func (v *progressView) Writer(output io.Writer) io.Writer {
+	return progressWriter{v, output}
+}
+
+func (v *progressView) Close() error {
+	v.closeOnce.Do(func() { close(v.doneC) })
+	v.wg.Wait()
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.done = true
+	return v.err
+}
+
+// cmd returns the command of the command event, creating it if needed.
+//
+// Must be called with the lock held.
+func (v *progressView) cmd(event *Event) *progressCmd {
+	cmd, ok := v.cmds[event.CmdID]
+	if !ok {
+		name := event.Name
+		tag := event.Name
+		if name == "" {
+			name = event.Cmd
+			tag = fmt.Sprint(event.CmdID)
+		}
+		cmd = &progressCmd{Name: name, Tag: tag, Cmd: event.Cmd}
+		v.cmds[event.CmdID] = cmd
+	}
+	return cmd
+}
+
+func (v *progressView) redrawLoop() {
+	defer v.wg.Done()
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-v.doneC:
+			return
+		case <-ticker.C:
+			v.lock.Lock()
+			if !v.done {
+				v.redraw()
+			}
+			v.lock.Unlock()
+		}
+	}
+}
+
+// redraw replaces the progress view on the terminal.
+//
+// Must be called with the lock held.
+func (v *progressView) redraw() {
+	v.clear()
+	lines := v.lines()
+	v.write(strings.Join(lines, "\n") + "\n")
+	v.numLines = len(lines)
+}
+
+// clear removes the progress view from the terminal.
+//
+// Must be called with the lock held.
+func (v *progressView) clear() {
+	if v.numLines > 0 {
+		v.write(fmt.Sprintf("\x1b[%dF\x1b[J", v.numLines))
+		v.numLines = 0
+	}
+}
+
+// lines returns the lines of the progress view.
+//
+// Must be called with the lock held.
+func (v *progressView) lines() []string {
+	now := v.clock()
+	counts := make(map[int]int)
+	var lines []string
+	for _, id := range v.sortedIDs() {
+		cmd := v.cmds[id]
+		counts[cmd.State]++
+		if cmd.State == progressRunning {
+			lines = append(lines, v.cut(fmt.Sprintf("%8s [%s] %s", progressDuration(now.Sub(cmd.StartTime)), cmd.Tag, cmd.Cmd)))
+		}
+	}
+	eta := "-"
+	if v.numFinished > 0 {
+		remaining := counts[progressQueued] + counts[progressRunning]
+		parallelism := v.maxConcurrentCmds
+		if parallelism <= 0 || parallelism > remaining {
+			parallelism = remaining
+		}
+		if parallelism > 0 {
+			average := v.finishedDuration / time.Duration(v.numFinished)
+			eta = progressDuration(average * time.Duration(remaining) / time.Duration(parallelism))
+		} else {
+			eta = progressDuration(0)
+		}
+	}
+	return append(lines, v.cut(fmt.Sprintf(
+		"queued %d  running %d  passed %d  failed %d  skipped %d  killed %d  eta %s",
+		counts[progressQueued],
+		counts[progressRunning],
+		counts[progressPassed],
+		counts[progressFailed],
+		counts[progressSkipped],
+		counts[progressKilled],
+		eta,
+	)))
+}
+
+// writeSummary writes the summary table for the EventTypeFinished event.
+//
+// Must be called with the lock held.
+func (v *progressView) writeSummary(event *Event) {
+	builder := &strings.Builder{}
+	tabWriter := tabwriter.NewWriter(builder, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "ID\tNAME\tSTATUS\tDURATION")
+	for _, id := range v.sortedIDs() {
+		cmd := v.cmds[id]
+		status := cmd.Status
+		if status == "" {
+			status = "unfinished"
+		}
+		fmt.Fprintf(tabWriter, "%d\t%s\t%s\t%s\n", id, cmd.Name, status, progressDuration(cmd.Duration))
+	}
+	_ = tabWriter.Flush()
+	v.write(builder.String())
+	result := "succeeded"
+	if event.Error != "" {
+		result = "failed: " + event.Error
+	}
+	v.write(fmt.Sprintf("%s in %s\n", result, progressDuration(event.Duration)))
+}
+
+// sortedIDs returns the IDs of the commands in order.
+//
+// Must be called with the lock held.
+func (v *progressView) sortedIDs() []int {
+	ids := make([]int, 0, len(v.cmds))
+	for id := range v.cmds {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// cut cuts the line to the width in columns so that it does not wrap,
+// without splitting UTF-8 characters or ANSI escape sequences, which
+// take no columns.
+func (v *progressView) cut(line string) string {
+	if v.width <= 0 {
+		return line
+	}
+	columns := 0
+	escaped := false
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			i += escapeLength(line[i:])
+			escaped = true
+			continue
+		}
+		if columns == v.width {
+			if escaped {
+				// reset the attributes that the sequences cut off
+				// would have reset
+				return line[:i] + "\x1b[0m"
+			}
+			return line[:i]
+		}
+		_, size := utf8.DecodeRuneInString(line[i:])
+		i += size
+		columns++
+	}
+	return line
+}
+
+// escapeLength returns the length of the ANSI escape sequence at the
+// start of s, which is a CSI sequence up to its final byte, or the
+// escape and the next byte otherwise.
+func escapeLength(s string) int {
+	if len(s) < 2 || s[1] != '[' {
+		return min(len(s), 2)
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i] >= 0x40 && s[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// write writes to the terminal, keeping the first error.
+//
+// Must be called with the lock held.
+func (v *progressView) write(s string) {
+	if v.err != nil {
+		return
+	}
+	if _, err := io.WriteString(v.terminal, s); err != nil {
+		v.err = err
+	}
+}
+
+func progressDuration(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+// progressWriter writes to its output above the progress view.
+type progressWriter struct {
+	view   *progressView
+	output io.Writer
+}
+
+func (w progressWriter) Write(data []byte) (int, error) {
+	v := w.view
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.clear()
+	n, err := w.output.Write(data)
+	if v.interval == 0 && !v.done && len(v.cmds) > 0 {
+		v.redraw()
+	}
+	return n, err
+}
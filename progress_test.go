@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProgressView(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	now := startTime
+	exitCode := 2
+	failedEvent := newCmdFinishedEvent(startTime.Add(2*time.Second), 1, testCmd("bar"), startTime, errors.New("command had error"))
+	failedEvent.ExitCode = &exitCode
+
+	terminal := newFakeTerminal()
+	view := NewProgressView(
+		terminal,
+		WithProgressWidth(30),
+		WithProgressInterval(0),
+		WithProgressClock(func() time.Time { return now }),
+	)
+	for _, event := range []*Event{
+		newStartedEvent(startTime, 3, 2),
+		newCmdQueuedEvent(startTime, 0, testCmd("foo")),
+		newCmdQueuedEvent(startTime, 1, testCmd("bar")),
+		newCmdQueuedEvent(startTime, 2, testCmd("a very long command line that is cut")),
+		newCmdStartedEvent(startTime, 0, testCmd("foo")),
+		newCmdStartedEvent(startTime, 1, testCmd("bar")),
+	} {
+		view.Handle(event)
+	}
+	now = startTime.Add(1500 * time.Millisecond)
+	if _, err := view.Writer(terminal).Write([]byte("[0] output\n")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"[0] output",
+		"    1.5s [0] foo",
+		"    1.5s [1] bar",
+		"queued 1  running 2  passed 0 ",
+	}
+	if diff := cmp.Diff(want, terminal.Screen()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	now = startTime.Add(2 * time.Second)
+	view.Handle(failedEvent)
+	view.Handle(newCmdStartedEvent(now, 2, testCmd("a very long command line that is cut")))
+	now = startTime.Add(3 * time.Second)
+	view.Handle(newCmdFinishedEvent(now, 0, testCmd("foo"), startTime, nil))
+	want = []string{
+		"[0] output",
+		"    1.0s [2] a very long comma",
+		"queued 0  running 1  passed 1 ",
+	}
+	if diff := cmp.Diff(want, terminal.Screen()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	view.Handle(newCmdSkippedEvent(now, 2, testCmd("baz"), skipReasonFastFail, nil))
+	view.Handle(newFinishedEvent(now, startTime, errCmdFailed))
+	if err := view.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"[0] output",
+		"ID  NAME                                  STATUS                DURATION",
+		"0   foo                                   passed                3.0s",
+		"1   bar                                   failed (exit code 2)  2.0s",
+		"2   a very long command line that is cut  skipped (fast_fail)   0.0s",
+		"failed: command failed in 3.0s",
+	}
+	if diff := cmp.Diff(want, terminal.Screen()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestProgressViewKilled(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	terminal := newFakeTerminal()
+	view := NewProgressView(terminal, WithProgressWidth(0), WithProgressInterval(0), WithProgressClock(func() time.Time { return startTime }))
+	// the command is killed at the end of the run, and has no error
+	for _, event := range []*Event{
+		newStartedEvent(startTime, 1, 1),
+		newCmdStartedEvent(startTime, 0, testCmd("foo")),
+		newCmdKilledEvent(startTime.Add(time.Second), 0, testCmd("foo"), false, nil),
+		newCmdStoppedEvent(startTime.Add(time.Second), 0, testCmd("foo"), startTime, false, nil),
+	} {
+		view.Handle(event)
+	}
+	want := []string{"queued 0  running 0  passed 0  failed 0  skipped 0  killed 1  eta 0.0s"}
+	if diff := cmp.Diff(want, terminal.Screen()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	view.Handle(newFinishedEvent(startTime.Add(time.Second), startTime, errCmdFailed))
+	if err := view.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"ID  NAME  STATUS  DURATION",
+		"0   foo   killed  1.0s",
+		"failed: command failed in 1.0s",
+	}
+	if diff := cmp.Diff(want, terminal.Screen()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestProgressViewETA(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	terminal := newFakeTerminal()
+	view := NewProgressView(terminal, WithProgressInterval(0), WithProgressClock(func() time.Time { return startTime }))
+	view.Handle(newStartedEvent(startTime, 5, 2))
+	for i := 0; i < 5; i++ {
+		view.Handle(newCmdQueuedEvent(startTime, i, testCmd(strconv.Itoa(i))))
+	}
+	view.Handle(newCmdStartedEvent(startTime, 0, testCmd("0")))
+	view.Handle(newCmdFinishedEvent(startTime.Add(2*time.Second), 0, testCmd("0"), startTime, nil))
+	// 4 commands of 2s each remaining with 2 at a time
+	screen := terminal.Screen()
+	if got := screen[len(screen)-1]; !strings.HasSuffix(got, "eta 4.0s") {
+		t.Fatalf("except eta 4.0s but got %q", got)
+	}
+}
+
+func TestProgressViewInterval(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	terminal := newFakeTerminal()
+	view := NewProgressView(terminal, WithProgressInterval(time.Hour), WithProgressClock(func() time.Time { return startTime }))
+	view.Handle(newStartedEvent(startTime, 1, 1))
+	view.Handle(newCmdStartedEvent(startTime, 0, testCmd("foo")))
+	// the events and writes do not redraw the view before the next
+	// interval, and the stderr of the commands is written to its own
+	// writer
+	stderr := &strings.Builder{}
+	if _, err := view.Writer(stderr).Write([]byte("[0] error\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string(nil), terminal.Screen()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("[0] error\n", stderr.String()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestProgressViewCut(t *testing.T) {
+	view := &progressView{width: 4}
+	for _, test := range []struct {
+		line string
+		want string
+	}{
+		{"abc", "abc"},
+		{"abcdef", "abcd"},
+		{"héllo", "héll"},
+		{"日本語の文", "日本語の"},
+		{"\x1b[31mabcdef\x1b[0m", "\x1b[31mabcd\x1b[0m"},
+		{"ab\x1b[1;32mcd\x1b[0m", "ab\x1b[1;32mcd\x1b[0m"},
+	} {
+		if diff := cmp.Diff(test.want, view.cut(test.line)); diff != "" {
+			t.Fatalf("%q (-want +got):\n%s", test.line, diff)
+		}
+	}
+}
+
+// fakeTerminal is a terminal that keeps the lines on its screen and
+// understands the escape sequences used by the progress view.
+type fakeTerminal struct {
+	lines []string
+	row   int
+}
+
+func newFakeTerminal() *fakeTerminal {
+	return &fakeTerminal{lines: []string{""}}
+}
+
+func (f *fakeTerminal) Write(data []byte) (int, error) {
+	s := string(data)
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, "\x1b["):
+			end := strings.IndexAny(s, "FJ")
+			if end < 0 {
+				return 0, fmt.Errorf("unknown escape sequence: %q", s)
+			}
+			switch s[end] {
+			case 'F':
+				n, err := strconv.Atoi(s[2:end])
+				if err != nil {
+					return 0, err
+				}
+				f.row -= n
+			case 'J':
+				f.lines = append(f.lines[:f.row], "")
+			}
+			s = s[end+1:]
+		case s[0] == '\n':
+			f.row++
+			if f.row == len(f.lines) {
+				f.lines = append(f.lines, "")
+			}
+			s = s[1:]
+		default:
+			f.lines[f.row] += s[:1]
+			s = s[1:]
+		}
+	}
+	return len(data), nil
+}
+
+// Screen returns the lines on the screen without the empty line
+// of the cursor.
+func (f *fakeTerminal) Screen() []string {
+	lines := f.lines
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return append([]string(nil), lines...)
+}
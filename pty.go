@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	exec "golang.org/x/sys/execabs"
+)
+
+var errPTYNotStarted = errors.New("pty command not started")
+
+// PTYOption is an option for a PTYCmd.
+type PTYOption func(*ptyCmd)
+
+// WithPTYSize returns a PTYOption that will set the initial window
+// size of the PTY.
+func WithPTYSize(rows uint16, cols uint16) PTYOption {
+	return func(cmd *ptyCmd) {
+		cmd.rows = rows
+		cmd.cols = cols
+	}
+}
+
+// PTYCmd is a Cmd that runs under a pseudo-terminal.
+type PTYCmd interface {
+	NamedCmd
+
+	// Resize sets the window size of the PTY of the running command.
+	Resize(rows uint16, cols uint16) error
+}
+
+// PTYExecCmd returns a new PTYCmd with the name for the given exec.Cmd
+// that runs it with its stdin, stdout and stderr attached to a new PTY.
+//
+// The output of the PTY, which merges stdout and stderr, is written to
+// the Stdout of the exec.Cmd, and its Stdin is written to the PTY. PTYs
+// are only supported on Linux, and Start returns an error elsewhere.
+func PTYExecCmd(ctx context.Context, name string, cmd *exec.Cmd, options ...PTYOption) PTYCmd {
+	return newPTYCmd(newExecCmd(ctx, cmd, name), options...)
+}
+
+type ptyCmd struct {
+	*execCmd
+
+	rows     uint16
+	cols     uint16
+	pty      *os.File
+	copyDone chan struct{}
+	lock     sync.Mutex
+}
+
+func newPTYCmd(cmd *execCmd, options ...PTYOption) *ptyCmd {
+	ptyCmd := &ptyCmd{execCmd: cmd}
+	for _, option := range options {
+		option(ptyCmd)
+	}
+	return ptyCmd
+}
+
+func (p *ptyCmd) Retry() (Cmd, error) {
+	cmd, err := p.execCmd.Retry()
+	if err != nil {
+		return nil, err
+	}
+	return newPTYCmd(cmd.(*execCmd), WithPTYSize(p.rows, p.cols)), nil
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package pexec
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// ptyEOF is the end-of-file character of the PTY.
+const ptyEOF = 4
+
+func (p *ptyCmd) Start() error {
+	stdin, stdout, stderr := p.Stdin, p.Stdout, p.Stderr
+	// pty only attaches the PTY to the nil streams, and the streams
+	// are restored after the start so that retries get them
+	p.Stdin, p.Stdout, p.Stderr = nil, nil, nil
+	var size *pty.Winsize
+	if p.rows != 0 || p.cols != 0 {
+		size = &pty.Winsize{Rows: p.rows, Cols: p.cols}
+	}
+	ptmx, err := pty.StartWithSize(p.Cmd, size)
+	p.Stdin, p.Stdout, p.Stderr = stdin, stdout, stderr
+	if err != nil {
+		return err
+	}
+	if ptmx, err = pollablePTY(ptmx); err != nil {
+		_ = p.Process.Kill()
+		_ = p.Cmd.Wait()
+		return err
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+
+	p.lock.Lock()
+	p.pty = ptmx
+	p.copyDone = make(chan struct{})
+	p.lock.Unlock()
+	go func() {
+		// the read fails with EIO once the command has exited
+		_, _ = io.Copy(stdout, ptmx)
+		close(p.copyDone)
+	}()
+	if stdin == nil {
+		// the command would wait on the PTY for input otherwise
+		_, _ = ptmx.Write([]byte{ptyEOF})
+		return nil
+	}
+	go func() {
+		_, _ = io.Copy(ptmx, stdin)
+		_, _ = ptmx.Write([]byte{ptyEOF})
+	}()
+	return nil
+}
+
+func (p *ptyCmd) Wait() error {
+	err := p.Cmd.Wait()
+	p.lock.Lock()
+	ptmx, copyDone := p.pty, p.copyDone
+	p.lock.Unlock()
+	if ptmx == nil {
+		return err
+	}
+	waitDelay := p.WaitDelay
+	if waitDelay == 0 {
+		waitDelay = DefaultWaitDelay
+	}
+	timer := time.NewTimer(waitDelay)
+	defer timer.Stop()
+	select {
+	case <-copyDone:
+	case <-timer.C:
+		// children of the command still have the PTY open, and
+		// closing it ends the copy
+	}
+	closeErr := ptmx.Close()
+	<-copyDone
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (p *ptyCmd) Resize(rows uint16, cols uint16) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.rows = rows
+	p.cols = cols
+	if p.pty == nil {
+		return errPTYNotStarted
+	}
+	conn, err := p.pty.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// Fd and pty.Setsize would make the PTY blocking again
+	controlErr := conn.Control(func(fd uintptr) {
+		err = unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, &unix.Winsize{Row: rows, Col: cols})
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return err
+}
+
+// pollablePTY returns a non-blocking copy of the PTY, which pty leaves
+// blocking, so that closing it ends the reads, and closes the PTY.
+func pollablePTY(ptmx *os.File) (*os.File, error) {
+	defer ptmx.Close()
+	fd, err := syscall.Dup(int(ptmx.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), ptmx.Name()), nil
+}
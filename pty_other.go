@@ -0,0 +1,22 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package pexec
+
+import "errors"
+
+var errPTYUnsupported = errors.New("pty is only supported on linux")
+
+func (p *ptyCmd) Start() error {
+	return errPTYUnsupported
+}
+
+func (p *ptyCmd) Wait() error {
+	return errPTYUnsupported
+}
+
+func (p *ptyCmd) Resize(rows uint16, cols uint16) error {
+	return errPTYUnsupported
+}
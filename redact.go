@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedText is the text that secrets are replaced with.
+const RedactedText = "***"
+
+// RedactorOption is an option for a Redactor.
+type RedactorOption func(*redactor)
+
+// WithRedactValues returns a RedactorOption that will redact the
+// literal values.
+func WithRedactValues(values ...string) RedactorOption {
+	return func(redactor *redactor) {
+		redactor.values = append(redactor.values, values...)
+	}
+}
+
+// WithRedactPatterns returns a RedactorOption that will redact the
+// matches of the regular expressions.
+func WithRedactPatterns(patterns ...*regexp.Regexp) RedactorOption {
+	return func(redactor *redactor) {
+		redactor.patterns = append(redactor.patterns, patterns...)
+	}
+}
+
+// WithRedactEnv returns a RedactorOption that will redact the values
+// of the environment variables with the names, as they are when the
+// Redactor is created.
+func WithRedactEnv(names ...string) RedactorOption {
+	return func(redactor *redactor) {
+		for _, name := range names {
+			redactor.values = append(redactor.values, os.Getenv(name))
+		}
+	}
+}
+
+// Redactor replaces secrets in text with RedactedText.
+type Redactor interface {
+	// Redact returns s with each secret replaced by RedactedText.
+	Redact(s string) string
+}
+
+// NewRedactor returns a new Redactor.
+//
+// Literal values are redacted before patterns, and empty values are
+// ignored.
+func NewRedactor(options ...RedactorOption) Redactor {
+	redactor := &redactor{}
+	for _, option := range options {
+		option(redactor)
+	}
+	var values []string
+	for _, value := range redactor.values {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	// longer values first so that a value containing another is
+	// redacted as a whole
+	sort.SliceStable(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	if len(values) > 0 {
+		oldnew := make([]string, 0, 2*len(values))
+		for _, value := range values {
+			oldnew = append(oldnew, value, RedactedText)
+		}
+		redactor.replacer = strings.NewReplacer(oldnew...)
+	}
+	return redactor
+}
+
+// NewRedactWriter returns a new io.WriteCloser that writes each line
+// written to it to the writer, redacted with the Redactor.
+//
+// Lines are buffered until their newline so that secrets split across
+// writes are redacted, and the lines of each Write are written with a
+// single Write call. Close writes the last line if it did not end with
+// a newline, and does not close the writer.
+func NewRedactWriter(writer io.Writer, redactor Redactor) io.WriteCloser {
+	return &redactWriter{writer: writer, redactor: redactor}
+}
+
+type redactor struct {
+	values   []string
+	patterns []*regexp.Regexp
+	replacer *strings.Replacer
+}
+
+func (r *redactor) Redact(s string) string {
+	if r.replacer != nil {
+		s = r.replacer.Replace(s)
+	}
+	for _, pattern := range r.patterns {
+		s = pattern.ReplaceAllLiteralString(s, RedactedText)
+	}
+	return s
+}
+
+// redactEvent redacts the string fields of the event.
+func redactEvent(redactor Redactor, event *Event) {
+	for _, field := range []*string{
+		&event.Error,
+		&event.Cmd,
+		&event.Name,
+		&event.Reason,
+		&event.Line,
+		&event.Stdout,
+		&event.Stderr,
+	} {
+		if *field != "" {
+			*field = redactor.Redact(*field)
+		}
+	}
+	for key, value := range event.Fields {
+		if s, ok := value.(string); ok {
+			event.Fields[key] = redactor.Redact(s)
+		}
+	}
+}
+
+type redactWriter struct {
+	writer   io.Writer
+	redactor Redactor
+	buffer   []byte
+	err      error
+	lock     sync.Mutex
+}
+
+func (r *redactWriter) Write(data []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.buffer = append(r.buffer, data...)
+	var output []byte
+	for {
+		index := bytes.IndexByte(r.buffer, '\n')
+		if index < 0 {
+			if len(r.buffer) < maxPrefixLineLength {
+				break
+			}
+			index = maxPrefixLineLength - 1
+		}
+		output = append(output, r.redactor.Redact(string(r.buffer[:index+1]))...)
+		r.buffer = r.buffer[index+1:]
+	}
+	if len(r.buffer) == 0 {
+		r.buffer = nil
+	}
+	if len(output) > 0 {
+		if _, err := r.writer.Write(output); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (r *redactWriter) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil || len(r.buffer) == 0 {
+		return r.err
+	}
+	output := r.redactor.Redact(string(r.buffer))
+	r.buffer = nil
+	if _, err := io.WriteString(r.writer, output); err != nil {
+		r.err = err
+	}
+	return r.err
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRedactor(t *testing.T) {
+	t.Setenv("PEXEC_TEST_TOKEN", "tok123")
+	redactor := NewRedactor(
+		WithRedactValues("secret", "secret-long", ""),
+		WithRedactEnv("PEXEC_TEST_TOKEN", "PEXEC_TEST_UNSET"),
+		WithRedactPatterns(regexp.MustCompile(`key=\w+`)),
+	)
+
+	got := redactor.Redact("secret-long secret tok123 key=abc public")
+	if diff := cmp.Diff("*** *** *** *** public", got); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	event := newCmdFinishedEvent(time.Time{}, 0, testCmd("curl -H tok123"), time.Time{}, nil)
+	event.Error = "failed with secret"
+	event.Stdout = "key=abc\n"
+	event.Fields = map[string]interface{}{"extra": "tok123", "number": 1}
+	redactEvent(redactor, event)
+	want := newCmdFinishedEvent(time.Time{}, 0, testCmd("curl -H ***"), time.Time{}, nil)
+	want.Error = "failed with ***"
+	want.Stdout = "***\n"
+	want.Fields = map[string]interface{}{"extra": "***", "number": 1}
+	if diff := cmp.Diff(want, event); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	buffer := &bytes.Buffer{}
+	writer := NewRedactWriter(buffer, redactor)
+	for _, data := range []string{"token tok1", "23\nkey=", "abc"} {
+		if _, err := writer.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if diff := cmp.Diff("token ***\n", buffer.String()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("token ***\n***", buffer.String()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
@@ -5,6 +5,7 @@ package pexec
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"os/signal"
 	"sync"
@@ -16,7 +17,15 @@ var errInterrupted = errors.New("runner interrupted by signal")
 type runner struct {
 	FastFail          bool
 	MaxConcurrentCmds int
+	Retries           int
+	OutputLimit       int
+	FailureOutput     bool
+	OutputEvents      *outputEventConfig
+	Redactor          Redactor
+	Skip              func(int, Cmd) string
+	EventHooks        []func(*Event, Cmd)
 	EventHandler      func(*Event)
+	Subscribers       []*subscriberConfig
 	Clock             func() time.Time
 }
 
@@ -24,7 +33,15 @@ func newRunner(options ...RunnerOption) *runner {
 	runner := &runner{
 		DefaultFastFail,
 		DefaultMaxConcurrentCmds,
+		DefaultRetries,
+		0,
+		false,
+		nil,
+		nil,
+		nil,
+		nil,
 		DefaultEventHandler,
+		nil,
 		DefaultClock,
 	}
 	for _, option := range options {
@@ -34,44 +51,96 @@ func newRunner(options ...RunnerOption) *runner {
 }
 
 func (r *runner) Run(cmds []Cmd) error {
+	return r.Start(cmds).Wait()
+}
+
+func (r *runner) Start(cmds []Cmd) Handle {
+	var subscribers []*subscriberConfig
+	if r.EventHandler != nil {
+		subscribers = append(subscribers, newSubscriberConfig(r.EventHandler))
+	}
+	eventBus := newEventBus(append(subscribers, r.Subscribers...))
+	handle := newRunHandle(r, eventBus)
+	handle.cmdControllers = make([]*cmdController, len(cmds))
+	for i, cmd := range cmds {
+		handle.cmdControllers[i] = newCmdController(i, cmd, handle.publish, r.Clock, r.Retries+1, r.OutputLimit, r.FailureOutput, r.OutputEvents)
+	}
+	handle.start()
+	return handle
+}
+
+type runHandle struct {
+	runner         *runner
+	eventBus       *eventBus
+	cmdControllers []*cmdController
 	// there is a race condition where err could be set to
 	// errCmdFailed or not set at all even after an interrupt happens
-	var err error
-	doneC := make(chan struct{})
-	cmdControllers := make([]*cmdController, len(cmds))
-	for i, cmd := range cmds {
-		cmdControllers[i] = newCmdController(cmd, r.EventHandler, r.Clock)
+	err       error
+	doneC     chan struct{}
+	startTime time.Time
+	// wg waits on the commands, including the killed ones, to exit.
+	wg       sync.WaitGroup
+	waitOnce sync.Once
+}
+
+func newRunHandle(runner *runner, eventBus *eventBus) *runHandle {
+	return &runHandle{
+		runner:   runner,
+		eventBus: eventBus,
+		doneC:    make(chan struct{}, 1),
 	}
+}
+
+// publish redacts the event, calls the event hooks and then publishes
+// the event to the subscribers.
+//
+// cmd is the Cmd the event is for, or nil for run events.
+func (h *runHandle) publish(event *Event, cmd Cmd) {
+	if h.runner.Redactor != nil {
+		redactEvent(h.runner.Redactor, event)
+	}
+	for _, eventHook := range h.runner.EventHooks {
+		eventHook(event, cmd)
+	}
+	h.eventBus.Publish(event)
+}
 
+func (h *runHandle) start() {
 	signalC := make(chan os.Signal, 1)
 	signal.Notify(signalC, os.Interrupt)
 	go func() {
 		for range signalC {
 			// do not want to acquire lock in the signal handler
-			err = errInterrupted
-			doneC <- struct{}{}
+			h.err = errInterrupted
+			h.done()
 			return
 		}
 	}()
 
-	var wg sync.WaitGroup
-	semaphore := newSemaphore(r.MaxConcurrentCmds)
+	semaphore := newSemaphore(h.runner.MaxConcurrentCmds)
 
-	startTime := r.Clock()
-	r.EventHandler(newStartedEvent(startTime))
-	for _, cmdController := range cmdControllers {
+	h.startTime = h.runner.Clock()
+	h.publish(newStartedEvent(h.startTime, len(h.cmdControllers), h.runner.MaxConcurrentCmds), nil)
+	for _, cmdController := range h.cmdControllers {
 		cmdController := cmdController
-		wg.Add(1)
+		if h.runner.Skip != nil {
+			if reason := h.runner.Skip(cmdController.ID, cmdController.Cmd); reason != "" {
+				cmdController.Skip(reason)
+				continue
+			}
+		}
+		h.wg.Add(1)
+		cmdController.Queue()
 		go func() {
 			semaphore.P(1)
 			defer semaphore.V(1)
-			defer wg.Done()
+			defer h.wg.Done()
 			if !cmdController.Run() {
 				// best effort to prioritize the interrupt error
 				// but this is not deterministic
-				err = errCmdFailed
-				if r.FastFail {
-					doneC <- struct{}{}
+				h.err = errCmdFailed
+				if h.runner.FastFail {
+					h.done()
 				}
 			}
 		}()
@@ -80,15 +149,75 @@ func (r *runner) Run(cmds []Cmd) error {
 		// if everything finishes and there is an interrupt, we could
 		// end up not actually returning an error if everything below
 		// completes before we context switch to the interrupt goroutine
-		wg.Wait()
-		doneC <- struct{}{}
+		h.wg.Wait()
+		h.done()
 	}()
-	// this waits on command completion, fast failure, or signal
-	<-doneC
-	for _, cmdController := range cmdControllers {
-		cmdController.Kill()
+}
+
+// done signals that the run is done without blocking if it was
+// already signalled.
+func (h *runHandle) done() {
+	select {
+	case h.doneC <- struct{}{}:
+	default:
 	}
-	finishTime := r.Clock()
-	r.EventHandler(newFinishedEvent(finishTime, startTime, err))
-	return err
+}
+
+func (h *runHandle) Running() []int {
+	var ids []int
+	for _, cmdController := range h.cmdControllers {
+		if cmdController.Running() {
+			ids = append(ids, cmdController.ID)
+		}
+	}
+	return ids
+}
+
+func (h *runHandle) Kill(id int) error {
+	return h.stop(id, false)
+}
+
+func (h *runHandle) Cancel(id int) error {
+	return h.stop(id, true)
+}
+
+func (h *runHandle) stop(id int, cancel bool) error {
+	if id < 0 || id >= len(h.cmdControllers) {
+		return fmt.Errorf("unknown command ID: %d", id)
+	}
+	return h.cmdControllers[id].Stop(cancel)
+}
+
+func (h *runHandle) Results() []*CmdResult {
+	results := make([]*CmdResult, len(h.cmdControllers))
+	for i, cmdController := range h.cmdControllers {
+		result := cmdController.Result()
+		if h.runner.Redactor != nil && result.Stdout != nil {
+			result.Stdout = []byte(h.runner.Redactor.Redact(string(result.Stdout)))
+			result.Stderr = []byte(h.runner.Redactor.Redact(string(result.Stderr)))
+		}
+		results[i] = result
+	}
+	return results
+}
+
+func (h *runHandle) Wait() error {
+	h.waitOnce.Do(func() {
+		// this waits on command completion, fast failure, or signal
+		<-h.doneC
+		skipReason := skipReasonFastFail
+		if h.err == errInterrupted {
+			skipReason = skipReasonInterrupted
+		}
+		for _, cmdController := range h.cmdControllers {
+			cmdController.Kill(skipReason)
+		}
+		// the final events of the killed commands are emitted once
+		// they have exited
+		h.wg.Wait()
+		finishTime := h.runner.Clock()
+		h.publish(newFinishedEvent(finishTime, h.startTime, h.err), nil)
+		h.eventBus.Close()
+	})
+	return h.err
 }
@@ -6,11 +6,15 @@ package pexec
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"io"
+	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	exec "golang.org/x/sys/execabs"
@@ -32,11 +36,19 @@ func TestBasic(t *testing.T) {
 	testEnv.eventHandler.StartedEventSuccess(t)
 	testEnv.eventHandler.FinishedEventSuccess(t)
 	testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdStarted, 5)
-	testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdFinished, 5)
+	cmdFinishedEvents := testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdFinished, 5)
 	if diff := cmp.Diff([]string{"1", "2", "3", "4", "5"}, testEnv.stdout.SortedLines(t)); diff != "" {
 		t.Fatalf("(-want +got):\n%s", diff)
 	}
 	// require.Equal(t, []string{"1", "2", "3", "4", "5"}, testEnv.stdout.SortedLines(t))
+	cmdIDs := make([]int, 0, len(cmdFinishedEvents))
+	for _, event := range cmdFinishedEvents {
+		cmdIDs = append(cmdIDs, event.CmdID)
+	}
+	sort.Ints(cmdIDs)
+	if diff := cmp.Diff([]int{0, 1, 2, 3, 4}, cmdIDs); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
 }
 
 func TestError(t *testing.T) {
@@ -57,13 +69,416 @@ func TestError(t *testing.T) {
 	testEnv.eventHandler.FinishedEventError(t)
 	testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdStarted, 5)
 	testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdFinished, 4)
-	testEnv.eventHandler.NumEventsForTypeError(t, EventTypeCmdFinished, 1)
+	errorEvent := testEnv.eventHandler.OneEventForTypeError(t, EventTypeCmdFinished)
+	if errorEvent.ExitCode == nil || *errorEvent.ExitCode != 1 {
+		t.Fatalf("except exit code is 1 but got %v", errorEvent.ExitCode)
+	}
+	if errorEvent.PID <= 0 {
+		t.Fatalf("except pid is positive but got %d", errorEvent.PID)
+	}
+	if errorEvent.MaxRSS <= 0 {
+		t.Fatalf("except max rss is positive but got %d", errorEvent.MaxRSS)
+	}
 	if diff := cmp.Diff([]string{"1", "2", "3", "4", "5"}, testEnv.stdout.SortedLines(t)); diff != "" {
 		t.Fatalf("(-want +got):\n%s", diff)
 	}
 	// require.Equal(t, []string{"1", "2", "3", "4", "5"}, testEnv.stdout.SortedLines(t))
 }
 
+func TestCmdIDs(t *testing.T) {
+	cmds := []Cmd{
+		NamedExecCmd(context.Background(), "first", newSimpleCmd(0, "1", 0)),
+		NamedExecCmd(context.Background(), "second", newSimpleCmd(0, "1", 0)),
+	}
+	eventHandler := newTestEventHandler()
+	if err := NewRunner(WithEventHandler(eventHandler.Handle)).Run(cmds); err != nil {
+		t.Fatal(err)
+	}
+
+	// the commands are the same, so only their IDs tell them apart
+	names := map[int]string{0: "first", 1: "second"}
+	numEvents := make(map[int]int)
+	for _, event := range eventHandler.events {
+		if !event.Type.isCmd() {
+			continue
+		}
+		if event.Cmd != cmds[0].String() || event.Name != names[event.CmdID] {
+			t.Fatalf("except command %d named %q but got %+v", event.CmdID, names[event.CmdID], event)
+		}
+		numEvents[event.CmdID]++
+	}
+	// queued, started and finished
+	if diff := cmp.Diff(map[int]int{0: 3, 1: 3}, numEvents); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestFastFail(t *testing.T) {
+	var cmds []*exec.Cmd
+	for i := 0; i < 10; i++ {
+		cmds = append(cmds, newSimpleCmd(0, strconv.Itoa(i), 1))
+	}
+	testEnv := newTestEnv(1, cmds, WithFastFail())
+	if err := testEnv.run(); err == nil {
+		t.Fatal("except err is non-nil")
+	}
+
+	testEnv.eventHandler.FinishedEventError(t)
+	testEnv.eventHandler.NumEventsForType(t, EventTypeCmdQueued, 10)
+	numStarted := len(testEnv.eventHandler.EventsForType(EventTypeCmdStarted))
+	skippedEvents := testEnv.eventHandler.EventsForType(EventTypeCmdSkipped)
+	if len(skippedEvents) == 0 || numStarted+len(skippedEvents) != 10 {
+		t.Fatalf("except 10 started or skipped commands but got %d started and %d skipped", numStarted, len(skippedEvents))
+	}
+	for _, event := range skippedEvents {
+		if event.Reason != skipReasonFastFail {
+			t.Fatalf("except skip reason %q but got %q", skipReasonFastFail, event.Reason)
+		}
+	}
+}
+
+func TestFastFailKill(t *testing.T) {
+	cmds := []*exec.Cmd{
+		newSimpleCmd(1, "1", 1),
+		newSimpleCmd(3, "2", 0),
+	}
+	testEnv := newTestEnv(5, cmds, WithFastFail())
+	if err := testEnv.run(); err == nil {
+		t.Fatal("except err is non-nil")
+	}
+
+	testEnv.eventHandler.FinishedEventError(t)
+	killedEvent := testEnv.eventHandler.OneEventForTypeSuccess(t, EventTypeCmdKilled)
+	if killedEvent.CmdID != 1 || killedEvent.Cancelled {
+		t.Fatalf("except command 1 killed but got %+v", killedEvent)
+	}
+	// the command killed at the end of the run is not recorded as
+	// failed, but it has the state of the killed process
+	finishedEvent := testEnv.eventHandler.OneEventForTypeSuccess(t, EventTypeCmdFinished)
+	if finishedEvent.CmdID != 1 || finishedEvent.Cancelled || finishedEvent.Signal != "killed" {
+		t.Fatalf("except command 1 finished with signal killed but got %+v", finishedEvent)
+	}
+	testEnv.eventHandler.OneEventForTypeError(t, EventTypeCmdFinished)
+}
+
+func TestRetries(t *testing.T) {
+	cmds := []*exec.Cmd{
+		newSimpleCmd(0, "1", 0),
+		newSimpleCmd(0, "2", 1),
+	}
+	testEnv := newTestEnv(5, cmds, WithRetries(2))
+	if err := testEnv.run(); err == nil {
+		t.Fatal("except err is non-nil")
+	}
+
+	testEnv.eventHandler.NumEventsForType(t, EventTypeCmdStarted, 4)
+	testEnv.eventHandler.NumEventsForTypeError(t, EventTypeCmdRetrying, 2)
+	errorEvent := testEnv.eventHandler.OneEventForTypeError(t, EventTypeCmdFinished)
+	if errorEvent.Attempt != 3 {
+		t.Fatalf("except attempt is 3 but got %d", errorEvent.Attempt)
+	}
+	if diff := cmp.Diff([]string{"1", "2", "2", "2"}, testEnv.stdout.SortedLines(t)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestOutputCapture(t *testing.T) {
+	cmds := []*exec.Cmd{
+		newSimpleCmd(0, "abc", 0),
+		newSimpleCmd(0, "hello", 1),
+	}
+	testEnv := newTestEnv(5, cmds, WithRetries(1), WithOutputCapture(4), WithFailureOutput())
+	handle := testEnv.start()
+	if err := handle.Wait(); err == nil {
+		t.Fatal("except err is non-nil")
+	}
+
+	errorEvent := testEnv.eventHandler.OneEventForTypeError(t, EventTypeCmdFinished)
+	if diff := cmp.Diff("llo\n", errorEvent.Stdout); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	results := handle.Results()
+	if len(results) != 2 {
+		t.Fatalf("except 2 results but got %d", len(results))
+	}
+	if diff := cmp.Diff([]byte("abc\n"), results[0].Stdout); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if results[0].StdoutTruncated || results[0].Event.Error != "" {
+		t.Fatalf("except untruncated successful result but got %+v", results[0])
+	}
+	if diff := cmp.Diff([]byte("llo\n"), results[1].Stdout); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if !results[1].StdoutTruncated || results[1].Event != errorEvent {
+		t.Fatalf("except truncated failed result but got %+v", results[1])
+	}
+	// the output is still written to the stdout of the commands
+	if diff := cmp.Diff([]string{"abc", "hello", "hello"}, testEnv.stdout.SortedLines(t)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestCmdOutputCapture(t *testing.T) {
+	cmds := []Cmd{
+		ExecCmd(context.Background(), newSimpleCmd(0, "hello", 0)),
+		ExecCmd(context.Background(), newSimpleCmd(0, "hello", 1), WithCmdOutputCapture(100)),
+		ExecCmd(context.Background(), newSimpleCmd(0, "hello", 0), WithCmdOutputCapture(0)),
+	}
+	runner := newRunner(WithEventHandler(newTestEventHandler().Handle), WithRetries(1), WithOutputCapture(4))
+	handle := runner.Start(cmds)
+	if err := handle.Wait(); err == nil {
+		t.Fatal("except err is non-nil")
+	}
+
+	results := handle.Results()
+	if diff := cmp.Diff([]byte("llo\n"), results[0].Stdout); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	// the limit of the command is kept for its retry
+	if diff := cmp.Diff([]byte("hello\n"), results[1].Stdout); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if results[1].Event.Attempt != 2 || results[1].StdoutTruncated {
+		t.Fatalf("except untruncated result of attempt 2 but got %+v", results[1])
+	}
+	if results[2].Stdout != nil {
+		t.Fatalf("except no captured output but got %q", results[2].Stdout)
+	}
+}
+
+func TestBackgroundChild(t *testing.T) {
+	// the child of the command keeps its captured stdout open after the
+	// command exits successfully
+	cmds := []Cmd{
+		ExecCmd(context.Background(), exec.Command("sh", "-c", "sleep 3 & echo hi")),
+	}
+	runner := newRunner(WithEventHandler(newTestEventHandler().Handle), WithOutputCapture(100))
+	handle := runner.Start(cmds)
+	if err := handle.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := handle.Results()[0]
+	if result.Event.Error != "" {
+		t.Fatalf("except successful result but got %+v", result.Event)
+	}
+	if diff := cmp.Diff([]byte("hi\n"), result.Stdout); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestOutputEvents(t *testing.T) {
+	cmds := []*exec.Cmd{
+		exec.Command("sh", "-c", "echo foo; echo barbaz >&2; printf qux"),
+		exec.Command("sh", "-c", "echo 1; echo 2; echo 3; echo 4"),
+	}
+	now := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	testEnv := newTestEnv(
+		5,
+		cmds,
+		WithOutputEvents(WithOutputEventMaxLineLength(3), WithOutputEventRateLimit(1, 3)),
+		WithClock(func() time.Time { return now }),
+	)
+	if err := testEnv.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	type line struct {
+		Stream       string
+		Line         string
+		DroppedLines int
+	}
+	lines := make(map[int][]line)
+	for _, event := range testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdOutput, 6) {
+		lines[event.CmdID] = append(lines[event.CmdID], line{event.Stream, event.Line, event.DroppedLines})
+	}
+	sort.SliceStable(lines[0], func(i, j int) bool { return lines[0][i].Stream > lines[0][j].Stream })
+	want := map[int][]line{
+		0: {{"stdout", "foo", 0}, {"stdout", "qux", 0}, {"stderr", "bar", 0}},
+		1: {{"stdout", "1", 0}, {"stdout", "2", 0}, {"stdout", "3", 0}},
+	}
+	if diff := cmp.Diff(want, lines); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	// the last line of the second command is dropped after its last
+	// output event
+	droppedLines := make(map[int]int)
+	for _, event := range testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdFinished, 2) {
+		droppedLines[event.CmdID] = event.DroppedLines
+	}
+	if diff := cmp.Diff(map[int]int{0: 0, 1: 1}, droppedLines); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	cmds := []*exec.Cmd{
+		newSimpleCmd(0, "secret", 1),
+	}
+	testEnv := newTestEnv(5, cmds, WithOutputCapture(100), WithRedactor(NewRedactor(WithRedactValues("secret"))))
+	handle := testEnv.start()
+	if err := handle.Wait(); err == nil {
+		t.Fatal("except err is non-nil")
+	}
+
+	for _, event := range testEnv.eventHandler.events {
+		if strings.Contains(event.Cmd, "secret") || strings.Contains(event.Error, "secret") {
+			t.Fatalf("except redacted event but got %+v", event)
+		}
+	}
+	if diff := cmp.Diff([]byte("***\n"), handle.Results()[0].Stdout); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestPTY(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pty is only supported on linux")
+	}
+	cmd := exec.Command("sh", "-c", "test -t 0 && test -t 1 && stty size")
+	stdout := newConcurrentReadWriter()
+	cmd.Stdout = stdout
+	eventHandler := newTestEventHandler()
+	runner := newRunner(WithEventHandler(eventHandler.Handle), WithOutputCapture(100))
+	handle := runner.Start([]Cmd{PTYExecCmd(context.Background(), "pty", cmd, WithPTYSize(24, 100))})
+	if err := handle.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"24 100"}, stdout.SortedLines(t)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]byte("24 100\r\n"), handle.Results()[0].Stdout); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestPTYWait(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pty is only supported on linux")
+	}
+	// the first command reads the PTY without a stdin, and the child of
+	// the second one keeps the PTY open after it exits
+	keepOpen := exec.Command("sh", "-c", "trap '' HUP; sleep 5 & echo done")
+	keepOpen.WaitDelay = 100 * time.Millisecond
+	cmds := []Cmd{
+		PTYExecCmd(context.Background(), "cat", exec.Command("cat")),
+		PTYExecCmd(context.Background(), "keep-open", keepOpen),
+	}
+	runner := newRunner(WithEventHandler(newTestEventHandler().Handle), WithOutputCapture(100))
+	startTime := time.Now()
+	handle := runner.Start(cmds)
+	if err := handle.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if duration := time.Since(startTime); duration > 3*time.Second {
+		t.Fatalf("except the commands to finish before their children but took %s", duration)
+	}
+	if diff := cmp.Diff([]byte("done\r\n"), handle.Results()[1].Stdout); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	cmds := []*exec.Cmd{
+		newSimpleCmd(0, "1", 0),
+		newSimpleCmd(0, "2", 1),
+		newSimpleCmd(0, "3", 0),
+	}
+	skip := func(id int, cmd Cmd) string {
+		if id == 1 {
+			return "test"
+		}
+		return ""
+	}
+	testEnv := newTestEnv(5, cmds, WithSkip(skip))
+	if err := testEnv.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	skippedEvent := testEnv.eventHandler.OneEventForTypeSuccess(t, EventTypeCmdSkipped)
+	if skippedEvent.CmdID != 1 || skippedEvent.Reason != "test" {
+		t.Fatalf("except command 1 skipped for test but got command %d skipped for %q", skippedEvent.CmdID, skippedEvent.Reason)
+	}
+	testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdFinished, 2)
+	if diff := cmp.Diff([]string{"1", "3"}, testEnv.stdout.SortedLines(t)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestKill(t *testing.T) {
+	cmds := []*exec.Cmd{
+		newSimpleCmd(0, "1", 0),
+		newSimpleCmd(2, "2", 0),
+		newSimpleCmd(0, "3", 0),
+	}
+	testEnv := newTestEnv(5, cmds)
+	handle := testEnv.start()
+	waitForRunning(t, handle, 1)
+	if err := handle.Kill(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := handle.Wait(); err == nil {
+		t.Fatal("except err is non-nil")
+	}
+
+	testEnv.eventHandler.FinishedEventError(t)
+	testEnv.eventHandler.OneEventForTypeSuccess(t, EventTypeCmdKilled)
+	testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdFinished, 2)
+	errorEvent := testEnv.eventHandler.OneEventForTypeError(t, EventTypeCmdFinished)
+	if errorEvent.Signal != "killed" || errorEvent.PID <= 0 {
+		t.Fatalf("except killed process state but got signal %q and pid %d", errorEvent.Signal, errorEvent.PID)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	cmds := []*exec.Cmd{
+		newSimpleCmd(0, "1", 0),
+		newSimpleCmd(2, "2", 0),
+		newSimpleCmd(0, "3", 0),
+	}
+	testEnv := newTestEnv(5, cmds)
+	handle := testEnv.start()
+	waitForRunning(t, handle, 1)
+	if err := handle.Cancel(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := handle.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	testEnv.eventHandler.FinishedEventSuccess(t)
+	cmdFinishedEvents := testEnv.eventHandler.NumEventsForTypeSuccess(t, EventTypeCmdFinished, 3)
+	var numCancelled int
+	for _, event := range cmdFinishedEvents {
+		if event.Cancelled {
+			numCancelled++
+		}
+	}
+	if numCancelled != 1 {
+		t.Fatalf("except 1 cancelled command but got %d", numCancelled)
+	}
+	if diff := cmp.Diff([]string{"1", "3"}, testEnv.stdout.SortedLines(t)); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func waitForRunning(t *testing.T, handle Handle, id int) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		for _, runningID := range handle.Running() {
+			if runningID == id {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("command %d never started running", id)
+}
+
 func newSimpleCmd(sleepSec int, echoString string, exitCode int) *exec.Cmd {
 	return exec.Command(
 		"./testdata/bin/simple.sh",
@@ -76,13 +491,13 @@ func newSimpleCmd(sleepSec int, echoString string, exitCode int) *exec.Cmd {
 type testEnv struct {
 	maxConcurrentCmds int
 	cmds              []*exec.Cmd
-	runner            *runner
+	runner            Starter
 	eventHandler      *testEventHandler
 	stdout            *testBuffer
 	stderr            *testBuffer
 }
 
-func newTestEnv(maxConcurrentCmds int, cmds []*exec.Cmd) *testEnv {
+func newTestEnv(maxConcurrentCmds int, cmds []*exec.Cmd, options ...RunnerOption) *testEnv {
 	stdout := newConcurrentReadWriter()
 	stderr := newConcurrentReadWriter()
 	for _, cmd := range cmds {
@@ -93,9 +508,14 @@ func newTestEnv(maxConcurrentCmds int, cmds []*exec.Cmd) *testEnv {
 	return &testEnv{
 		maxConcurrentCmds,
 		cmds,
-		newRunner(
-			WithMaxConcurrentCmds(maxConcurrentCmds),
-			WithEventHandler(eventHandler.Handle),
+		NewStarter(
+			append(
+				[]RunnerOption{
+					WithMaxConcurrentCmds(maxConcurrentCmds),
+					WithEventHandler(eventHandler.Handle),
+				},
+				options...,
+			)...,
 		),
 		eventHandler,
 		stdout,
@@ -104,7 +524,11 @@ func newTestEnv(maxConcurrentCmds int, cmds []*exec.Cmd) *testEnv {
 }
 
 func (e *testEnv) run() error {
-	return e.runner.Run(ExecCmds(e.cmds))
+	return e.runner.Run(ExecCmds(context.Background(), e.cmds))
+}
+
+func (e *testEnv) start() Handle {
+	return e.runner.Start(ExecCmds(context.Background(), e.cmds))
 }
 
 type testEventHandler struct {
@@ -161,7 +585,7 @@ func (e *testEventHandler) NumEventsForType(t *testing.T, eventType EventType, n
 
 	eventsForType := e.EventsForType(eventType)
 	if len(eventsForType) != num {
-		t.Fatal("except eventsForType length is %d but got %d", num, len(eventsForType))
+		t.Fatalf("except eventsForType length is %d but got %d", num, len(eventsForType))
 	}
 	// require.Len(t, eventsForType, num)
 	return eventsForType
@@ -172,7 +596,7 @@ func (e *testEventHandler) NumEventsForTypeSuccess(t *testing.T, eventType Event
 
 	eventsForType := e.EventsForTypeSuccess(eventType)
 	if len(eventsForType) != num {
-		t.Fatal("except eventsForType length is %d but got %d", num, len(eventsForType))
+		t.Fatalf("except eventsForType length is %d but got %d", num, len(eventsForType))
 	}
 	// require.Len(t, eventsForType, num)
 	return eventsForType
@@ -183,7 +607,7 @@ func (e *testEventHandler) NumEventsForTypeError(t *testing.T, eventType EventTy
 
 	eventsForType := e.EventsForTypeError(eventType)
 	if len(eventsForType) != num {
-		t.Fatal("except eventsForType length is %d but got %d", num, len(eventsForType))
+		t.Fatalf("except eventsForType length is %d but got %d", num, len(eventsForType))
 	}
 	// require.Len(t, eventsForType, num)
 	return eventsForType
@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+// NewSlogEventHandler returns an event handler that logs each event
+// as a slog record with the event type as the message, at
+// slog.LevelError for events with an error and slog.LevelInfo
+// otherwise.
+//
+// The record has the time of the event, and an attribute for each set
+// field of the event. To use it for all Runners, set DefaultEventHandler.
+func NewSlogEventHandler(logger *slog.Logger) func(*Event) {
+	return func(event *Event) {
+		logEventSlog(logger, event)
+	}
+}
+
+func logEventSlog(logger *slog.Logger, event *Event) {
+	ctx := context.Background()
+	level := slog.LevelInfo
+	if event.Error != "" {
+		level = slog.LevelError
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	record := slog.NewRecord(event.Time, level, event.Type.String(), 0)
+	record.AddAttrs(eventSlogAttrs(event)...)
+	_ = logger.Handler().Handle(ctx, record)
+}
+
+// eventSlogAttrs returns the attributes for the set fields of the
+// event, which are encoded as they are by MarshalJSON.
+func eventSlogAttrs(event *Event) []slog.Attr {
+	fields := event.encodeFields()
+	attrs := make([]slog.Attr, 0, len(fields)+len(event.Fields)+1)
+	keys := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+		keys[field.Key] = struct{}{}
+	}
+	extraKeys := make([]string, 0, len(event.Fields))
+	for key := range event.Fields {
+		if _, ok := keys[key]; !ok {
+			extraKeys = append(extraKeys, key)
+		}
+	}
+	sort.Strings(extraKeys)
+	for _, key := range extraKeys {
+		attrs = append(attrs, slog.Any(key, event.Fields[key]))
+	}
+	if event.Error != "" {
+		attrs = append(attrs, slog.String("error", event.Error))
+	}
+	return attrs
+}
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSlogEventHandler(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	handler := NewSlogEventHandler(slog.New(slog.NewTextHandler(buffer, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	for _, event := range testRunEvents() {
+		handler(event)
+	}
+
+	want := `time=2021-04-01T00:00:00.000Z level=INFO msg=started num_cmds=5
+time=2021-04-01T00:00:00.000Z level=INFO msg=cmd_started cmd_id=0 cmd=foo
+time=2021-04-01T00:00:01.000Z level=INFO msg=cmd_started cmd_id=1 cmd=bar
+time=2021-04-01T00:00:01.000Z level=INFO msg=cmd_started cmd_id=3 cmd=qux
+time=2021-04-01T00:00:01.000Z level=INFO msg=cmd_started cmd_id=4 cmd=quux
+time=2021-04-01T00:00:01.500Z level=ERROR msg=cmd_retrying cmd_id=1 cmd=bar attempt=1 duration=500ms exit_code=1 error="command had error: bar"
+time=2021-04-01T00:00:01.500Z level=INFO msg=cmd_started cmd_id=1 cmd=bar attempt=2
+time=2021-04-01T00:00:02.000Z level=INFO msg=cmd_killed cmd_id=4 cmd=quux cancelled=true
+time=2021-04-01T00:00:02.000Z level=INFO msg=cmd_finished cmd_id=4 cmd=quux duration=1s cancelled=true exit_code=-1 signal=killed signal_number=9
+time=2021-04-01T00:00:02.500Z level=ERROR msg=cmd_finished cmd_id=1 cmd=bar attempt=2 duration=1s exit_code=2 stdout="bar out\n" stderr="bar err\n" error="command had error: bar"
+time=2021-04-01T00:00:03.000Z level=INFO msg=cmd_finished cmd_id=0 cmd=foo duration=3s exit_code=0
+time=2021-04-01T00:00:03.000Z level=INFO msg=cmd_skipped cmd_id=2 cmd=baz reason=fast_fail
+time=2021-04-01T00:00:03.000Z level=INFO msg=cmd_killed cmd_id=3 cmd=qux
+time=2021-04-01T00:00:03.000Z level=INFO msg=cmd_finished cmd_id=3 cmd=qux duration=2s exit_code=-1 signal=killed signal_number=9
+time=2021-04-01T00:00:03.000Z level=ERROR msg=finished duration=3s error="command failed"
+`
+	if diff := cmp.Diff(want, buffer.String()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	buffer.Reset()
+	handler = NewSlogEventHandler(slog.New(slog.NewTextHandler(buffer, &slog.HandlerOptions{Level: slog.LevelError})))
+	handler(newCmdStartedEvent(testRunStartTime, 0, testCmd("foo")))
+	if buffer.Len() != 0 {
+		t.Fatalf("expected no output below the level but got %q", buffer.String())
+	}
+}
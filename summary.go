@@ -0,0 +1,303 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// SummaryOption is an option for a SummarySink.
+type SummaryOption func(*summarySink)
+
+// WithSummarySlowest returns a SummaryOption that will list the n
+// slowest commands in the Summary.
+func WithSummarySlowest(n int) SummaryOption {
+	return func(sink *summarySink) {
+		sink.slowest = n
+	}
+}
+
+// WithSummaryOutputLines returns a SummaryOption that will keep the
+// last n lines of the output of each failed command in the Summary.
+func WithSummaryOutputLines(n int) SummaryOption {
+	return func(sink *summarySink) {
+		sink.outputLines = n
+	}
+}
+
+// SummarySink is an EventSink that builds a Summary of a run from
+// its events.
+type SummarySink interface {
+	EventSink
+	// Summary returns the summary of the events handled so far.
+	Summary() *Summary
+	// AddResults adds the captured output of the results, such as
+	// those of Handle.Results, for the failed commands without
+	// EventTypeCmdOutput events.
+	AddResults(results []*CmdResult)
+}
+
+// NewSummarySink returns a new SummarySink.
+//
+// The output of failed commands is taken from their
+// EventTypeCmdOutput events if there are any, from the results added
+// with AddResults, or from the Stdout and Stderr of their failed
+// EventTypeCmdFinished events otherwise.
+func NewSummarySink(options ...SummaryOption) SummarySink {
+	sink := &summarySink{
+		slowest:     DefaultSummarySlowest,
+		outputLines: DefaultSummaryOutputLines,
+		cmds:        make(map[int]*summaryCmd),
+	}
+	for _, option := range options {
+		option(sink)
+	}
+	return sink
+}
+
+// Summary is the summary of a run.
+type Summary struct {
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Killed    int           `json:"killed"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+	// Slowest are the slowest finished commands, slowest first.
+	Slowest []*SummaryCmd `json:"slowest,omitempty"`
+	// Failures are the failed commands in the order of their IDs.
+	Failures []*SummaryCmd `json:"failures,omitempty"`
+}
+
+// SummaryCmd is a command in a Summary.
+type SummaryCmd struct {
+	ID       int           `json:"id"`
+	Name     string        `json:"name,omitempty"`
+	Cmd      string        `json:"cmd"`
+	Duration time.Duration `json:"duration"`
+	ExitCode *int          `json:"exit_code,omitempty"`
+	Signal   string        `json:"signal,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Output is the last lines of the output of a failed command.
+	Output []string `json:"output,omitempty"`
+}
+
+// MarshalJSON marshals the Summary to JSON with the duration as a
+// string, like Event.
+func (s *Summary) MarshalJSON() ([]byte, error) {
+	type rawSummary Summary
+	return json.Marshal(&struct {
+		*rawSummary
+		Duration string `json:"duration"`
+	}{(*rawSummary)(s), s.Duration.String()})
+}
+
+// MarshalJSON marshals the SummaryCmd to JSON with the duration as a
+// string, like Event.
+func (c *SummaryCmd) MarshalJSON() ([]byte, error) {
+	type rawSummaryCmd SummaryCmd
+	return json.Marshal(&struct {
+		*rawSummaryCmd
+		Duration string `json:"duration"`
+	}{(*rawSummaryCmd)(c), c.Duration.String()})
+}
+
+// WriteText writes the summary in a human-readable form.
+func (s *Summary) WriteText(writer io.Writer) error {
+	builder := &strings.Builder{}
+	fmt.Fprintf(
+		builder,
+		"%d commands in %s: %d succeeded, %d failed, %d skipped, %d killed\n",
+		s.Total,
+		summaryDuration(s.Duration),
+		s.Succeeded,
+		s.Failed,
+		s.Skipped,
+		s.Killed,
+	)
+	if len(s.Slowest) > 0 {
+		builder.WriteString("\nslowest:\n")
+		for _, cmd := range s.Slowest {
+			fmt.Fprintf(builder, "  %8s  %s\n", summaryDuration(cmd.Duration), cmd.title())
+		}
+	}
+	for _, cmd := range s.Failures {
+		result := "error"
+		switch {
+		case cmd.Signal != "":
+			result = "signal " + cmd.Signal
+		case cmd.ExitCode != nil:
+			result = fmt.Sprintf("exit code %d", *cmd.ExitCode)
+		}
+		fmt.Fprintf(builder, "\nfailed: %s (%s)\n", cmd.title(), result)
+		for _, line := range cmd.Output {
+			fmt.Fprintf(builder, "  | %s\n", line)
+		}
+	}
+	_, err := io.WriteString(writer, builder.String())
+	return err
+}
+
+func (c *SummaryCmd) title() string {
+	if c.Name != "" {
+		return fmt.Sprintf("[%d] %s", c.ID, c.Name)
+	}
+	return fmt.Sprintf("[%d] %s", c.ID, c.Cmd)
+}
+
+type summarySink struct {
+	slowest     int
+	outputLines int
+	numCmds     int
+	finished    *Event
+	cmds        map[int]*summaryCmd
+	lock        sync.Mutex
+}
+
+type summaryCmd struct {
+	Event  *Event
+	Killed bool
+	// OutputLines are the last lines of the EventTypeCmdOutput events.
+	OutputLines []string
+	// Result is the result added with AddResults.
+	Result *CmdResult
+}
+
+func (s *summarySink) Handle(event *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch event.Type {
+	case EventTypeStarted:
+		s.numCmds = event.NumCmds
+	case EventTypeCmdRetrying:
+		// only keep the output of the last attempt
+		s.cmd(event.CmdID).OutputLines = nil
+	case EventTypeCmdOutput:
+		cmd := s.cmd(event.CmdID)
+		cmd.OutputLines = lastLines(append(cmd.OutputLines, event.Line), s.outputLines)
+	case EventTypeCmdKilled:
+		s.cmd(event.CmdID).Killed = !event.Cancelled
+	case EventTypeCmdFinished, EventTypeCmdSkipped:
+		s.cmd(event.CmdID).Event = event
+	case EventTypeFinished:
+		s.finished = event
+	}
+}
+
+func (s *summarySink) AddResults(results []*CmdResult) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, result := range results {
+		if result.Stdout != nil {
+			s.cmd(result.ID).Result = result
+		}
+	}
+}
+
+func (s *summarySink) Close() error {
+	return nil
+}
+
+func (s *summarySink) Summary() *Summary {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	summary := &Summary{Total: s.numCmds}
+	if s.finished != nil {
+		summary.Duration = s.finished.Duration
+		summary.Error = s.finished.Error
+	}
+	var finished []*SummaryCmd
+	ids := make([]int, 0, len(s.cmds))
+	for id := range s.cmds {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		cmd := s.cmds[id]
+		event := cmd.Event
+		if event == nil {
+			continue
+		}
+		summaryCmd := &SummaryCmd{
+			ID:       id,
+			Name:     event.Name,
+			Cmd:      event.Cmd,
+			Duration: event.Duration,
+			ExitCode: event.ExitCode,
+			Signal:   event.Signal,
+			Error:    event.Error,
+		}
+		switch {
+		case event.Type == EventTypeCmdSkipped || event.Cancelled:
+			summary.Skipped++
+			continue
+		case cmd.Killed:
+			summary.Killed++
+		case event.Error != "":
+			summary.Failed++
+			failure := *summaryCmd
+			failure.Output = cmd.OutputLines
+			if failure.Output == nil && cmd.Result != nil {
+				failure.Output = lastLines(append(outputLines(string(cmd.Result.Stdout)), outputLines(string(cmd.Result.Stderr))...), s.outputLines)
+			}
+			if failure.Output == nil {
+				failure.Output = lastLines(append(outputLines(event.Stdout), outputLines(event.Stderr)...), s.outputLines)
+			}
+			summary.Failures = append(summary.Failures, &failure)
+		default:
+			summary.Succeeded++
+		}
+		finished = append(finished, summaryCmd)
+	}
+	sort.SliceStable(finished, func(i, j int) bool {
+		return finished[i].Duration > finished[j].Duration
+	})
+	if len(finished) > s.slowest {
+		finished = finished[:s.slowest]
+	}
+	summary.Slowest = finished
+	return summary
+}
+
+// cmd returns the command with the ID, creating it if needed.
+//
+// Must be called with the lock held.
+func (s *summarySink) cmd(id int) *summaryCmd {
+	cmd, ok := s.cmds[id]
+	if !ok {
+		cmd = &summaryCmd{}
+		s.cmds[id] = cmd
+	}
+	return cmd
+}
+
+// summaryDuration formats a duration in a summary as seconds.
+func summaryDuration(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+// outputLines splits the output into lines.
+func outputLines(output string) []string {
+	output = strings.TrimSuffix(output, "\n")
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, "\n")
+}
+
+// lastLines returns the last n lines.
+func lastLines(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
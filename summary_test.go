@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSummarySink(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	exitCode := 2
+	failedEvent := newCmdFinishedEvent(startTime.Add(2*time.Second), 1, testCmd("bar"), startTime, errors.New("command had error"))
+	failedEvent.ExitCode = &exitCode
+	failedEvent.Stdout = "one\ntwo\n"
+	failedEvent.Stderr = "three\n"
+	killedEvent := newCmdStoppedEvent(startTime.Add(3*time.Second), 3, testCmd("qux"), startTime, false, errCmdKilled)
+
+	sink := NewSummarySink(WithSummarySlowest(2), WithSummaryOutputLines(2))
+	for _, event := range []*Event{
+		newStartedEvent(startTime, 5, 0),
+		newCmdFinishedEvent(startTime.Add(time.Second), 0, testCmd("foo"), startTime, nil),
+		failedEvent,
+		newCmdSkippedEvent(startTime.Add(3*time.Second), 2, testCmd("baz"), skipReasonFastFail, nil),
+		newCmdKilledEvent(startTime.Add(3*time.Second), 3, testCmd("qux"), false, nil),
+		killedEvent,
+		newFinishedEvent(startTime.Add(3*time.Second), startTime, errCmdFailed),
+	} {
+		sink.Handle(event)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	summary := sink.Summary()
+
+	buffer := &bytes.Buffer{}
+	if err := summary.WriteText(buffer); err != nil {
+		t.Fatal(err)
+	}
+	want := `5 commands in 3.0s: 1 succeeded, 1 failed, 1 skipped, 1 killed
+
+slowest:
+      3.0s  [3] qux
+      2.0s  [1] bar
+
+failed: [1] bar (exit code 2)
+  | two
+  | three
+`
+	if diff := cmp.Diff(want, buffer.String()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]interface{})
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("3s", got["duration"]); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+	failures, ok := got["failures"].([]interface{})
+	if !ok || len(failures) != 1 {
+		t.Fatalf("except one failure but got %v", got["failures"])
+	}
+	if diff := cmp.Diff(map[string]interface{}{
+		"id":        float64(1),
+		"cmd":       "bar",
+		"duration":  "2s",
+		"exit_code": float64(2),
+		"error":     "command had error",
+		"output":    []interface{}{"two", "three"},
+	}, failures[0]); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestSummarySinkResults(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	sink := NewSummarySink(WithSummaryOutputLines(2))
+	for _, event := range []*Event{
+		newStartedEvent(startTime, 2, 0),
+		newCmdFinishedEvent(startTime.Add(time.Second), 0, testCmd("foo"), startTime, errors.New("command had error")),
+		newCmdFinishedEvent(startTime.Add(time.Second), 1, testCmd("bar"), startTime, nil),
+		newFinishedEvent(startTime.Add(time.Second), startTime, errCmdFailed),
+	} {
+		sink.Handle(event)
+	}
+	sink.AddResults([]*CmdResult{
+		{ID: 0, Stdout: []byte("one\ntwo\n"), Stderr: []byte("three\n")},
+		{ID: 1, Stdout: []byte("four\n"), Stderr: []byte{}},
+	})
+
+	summary := sink.Summary()
+	if len(summary.Failures) != 1 {
+		t.Fatalf("except one failure but got %d", len(summary.Failures))
+	}
+	if diff := cmp.Diff([]string{"two", "three"}, summary.Failures[0].Output); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
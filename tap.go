@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// NewTAPSink returns a new EventSink that writes the results of the
+// commands to the writer in the Test Anything Protocol version 13.
+//
+// The plan is written when the run starts, and a test line is written
+// for each command as it finishes, numbered by the command ID plus one.
+// Commands that the runner killed are not ok. Close does not close the
+// writer.
+func NewTAPSink(writer io.Writer) EventSink {
+	return &tapSink{writer: writer, killedCmdIDs: make(map[int]struct{})}
+}
+
+// tapDiagnostic is the YAML diagnostic block of a TAP test line.
+type tapDiagnostic struct {
+	Duration string `yaml:"duration"`
+	ExitCode *int   `yaml:"exit_code,omitempty"`
+	Signal   string `yaml:"signal,omitempty"`
+	Message  string `yaml:"message,omitempty"`
+}
+
+type tapSink struct {
+	writer       io.Writer
+	killedCmdIDs map[int]struct{}
+	err          error
+	lock         sync.Mutex
+}
+
+func (s *tapSink) Handle(event *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return
+	}
+	switch event.Type {
+	case EventTypeStarted:
+		_, s.err = fmt.Fprintf(s.writer, "TAP version 13\n1..%d\n", event.NumCmds)
+	case EventTypeCmdKilled:
+		s.killedCmdIDs[event.CmdID] = struct{}{}
+	case EventTypeCmdFinished:
+		s.err = s.writeTestLine(event)
+	case EventTypeCmdSkipped:
+		_, s.err = fmt.Fprintf(s.writer, "ok %d - %s # SKIP %s\n", event.CmdID+1, tapDescription(event), event.Reason)
+	case EventTypeFinished:
+		if event.Error != "" {
+			_, s.err = fmt.Fprintf(s.writer, "# %s\n", event.Error)
+		}
+	}
+}
+
+func (s *tapSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// writeTestLine writes the test line for a EventTypeCmdFinished event.
+//
+// Must be called with the lock held.
+func (s *tapSink) writeTestLine(event *Event) error {
+	buffer := &bytes.Buffer{}
+	message := event.Error
+	_, killed := s.killedCmdIDs[event.CmdID]
+	switch {
+	case event.Cancelled:
+		fmt.Fprintf(buffer, "ok %d - %s # SKIP %s\n", event.CmdID+1, tapDescription(event), skipReasonCancelled)
+	case event.Error != "":
+		fmt.Fprintf(buffer, "not ok %d - %s\n", event.CmdID+1, tapDescription(event))
+	case killed || event.Signal != "":
+		// commands killed at the end of the run have no error
+		fmt.Fprintf(buffer, "not ok %d - %s\n", event.CmdID+1, tapDescription(event))
+		message = skipReasonKilled
+	default:
+		fmt.Fprintf(buffer, "ok %d - %s\n", event.CmdID+1, tapDescription(event))
+	}
+	data, err := yaml.Marshal(&tapDiagnostic{
+		Duration: event.Duration.String(),
+		ExitCode: event.ExitCode,
+		Signal:   event.Signal,
+		Message:  message,
+	})
+	if err != nil {
+		return err
+	}
+	buffer.WriteString("  ---\n")
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n") {
+		buffer.WriteString("  " + line)
+	}
+	buffer.WriteString("\n  ...\n")
+	_, err = s.writer.Write(buffer.Bytes())
+	return err
+}
+
+// tapDescription returns the description of the command's test line.
+func tapDescription(event *Event) string {
+	description := event.Name
+	if description == "" {
+		description = event.Cmd
+	}
+	// "#" starts a directive in a test line
+	return strings.ReplaceAll(description, "#", `\#`)
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTAPSink(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	sink := NewTAPSink(buffer)
+	for _, event := range testRunEvents() {
+		sink.Handle(event)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `TAP version 13
+1..5
+ok 5 - quux # SKIP cancelled
+  ---
+  duration: 1s
+  exit_code: -1
+  signal: killed
+  ...
+not ok 2 - bar
+  ---
+  duration: 1s
+  exit_code: 2
+  message: "command had error: bar"
+  ...
+ok 1 - foo
+  ---
+  duration: 3s
+  exit_code: 0
+  ...
+ok 3 - baz # SKIP fast_fail
+not ok 4 - qux
+  ---
+  duration: 2s
+  exit_code: -1
+  signal: killed
+  message: killed
+  ...
+# command failed
+`
+	if diff := cmp.Diff(want, buffer.String()); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
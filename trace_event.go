@@ -0,0 +1,222 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+const (
+	// traceEventSlotsPID is the trace process of the concurrency slot lanes.
+	traceEventSlotsPID = 1
+	// traceEventQueuePID is the trace process of the queue wait lanes.
+	traceEventQueuePID = 2
+)
+
+// NewTraceEventSink returns a new EventSink that writes a timeline of
+// the run in the Chrome Trace Event format to the writer when the run
+// finishes, which can be loaded into chrome://tracing or Perfetto.
+//
+// Each attempt of a command is a complete event on the lane of the
+// concurrency slot it ran in, and the time each command waited for a
+// slot is a complete event on its own lane. Close does not close the
+// writer.
+func NewTraceEventSink(writer io.Writer) EventSink {
+	return newTraceEventSink(writer, nil)
+}
+
+// CreateTraceEventSink returns a new EventSink that creates or
+// truncates the file at filePath and writes a timeline of the run in
+// the Chrome Trace Event format to it when the run finishes.
+//
+// The file is closed by Close.
+func CreateTraceEventSink(filePath string) (EventSink, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return newTraceEventSink(file, file), nil
+}
+
+// traceEvent is an event in the Chrome Trace Event format.
+type traceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat,omitempty"`
+	Phase     string                 `json:"ph"`
+	Timestamp int64                  `json:"ts"`
+	Duration  int64                  `json:"dur,omitempty"`
+	PID       int                    `json:"pid"`
+	TID       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+type traceEventFile struct {
+	TraceEvents     []*traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string        `json:"displayTimeUnit"`
+}
+
+type traceEventSink struct {
+	writer    io.Writer
+	closer    io.Closer
+	startTime time.Time
+	// the slot lane of each running command
+	cmdSlots map[int]int
+	// the start time of each queued or running command
+	cmdStartTimes map[int]time.Time
+	// true for each slot lane in use
+	slots       []bool
+	traceEvents []*traceEvent
+	err         error
+	lock        sync.Mutex
+}
+
+func newTraceEventSink(writer io.Writer, closer io.Closer) *traceEventSink {
+	return &traceEventSink{
+		writer:        writer,
+		closer:        closer,
+		cmdSlots:      make(map[int]int),
+		cmdStartTimes: make(map[int]time.Time),
+	}
+}
+
+func (s *traceEventSink) Handle(event *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch event.Type {
+	case EventTypeStarted:
+		s.startTime = event.Time
+	case EventTypeCmdQueued:
+		s.cmdStartTimes[event.CmdID] = event.Time
+	case EventTypeCmdStarted:
+		if queueTime, ok := s.cmdStartTimes[event.CmdID]; ok && event.Attempt <= 1 {
+			s.addCompleteEvent("queued", "queue", traceEventQueuePID, event.CmdID, queueTime, event.Time, map[string]interface{}{
+				"cmd_id": event.CmdID,
+			})
+		}
+		if _, ok := s.cmdSlots[event.CmdID]; !ok {
+			s.cmdSlots[event.CmdID] = s.acquireSlot()
+		}
+		s.cmdStartTimes[event.CmdID] = event.Time
+	case EventTypeCmdRetrying:
+		s.addCmdEvent(event)
+	case EventTypeCmdFinished:
+		s.addCmdEvent(event)
+		if slot, ok := s.cmdSlots[event.CmdID]; ok {
+			s.slots[slot] = false
+			delete(s.cmdSlots, event.CmdID)
+		}
+		delete(s.cmdStartTimes, event.CmdID)
+	case EventTypeFinished:
+		if s.err == nil {
+			s.err = s.write()
+		}
+	}
+}
+
+func (s *traceEventSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.err
+	if s.closer != nil {
+		if closeErr := s.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// acquireSlot returns the lowest free slot lane and marks it as in use.
+//
+// Must be called with the lock held.
+func (s *traceEventSink) acquireSlot() int {
+	for slot, inUse := range s.slots {
+		if !inUse {
+			s.slots[slot] = true
+			return slot
+		}
+	}
+	s.slots = append(s.slots, true)
+	return len(s.slots) - 1
+}
+
+// addCmdEvent adds the complete event for the attempt of the command
+// that ended with the EventTypeCmdRetrying or EventTypeCmdFinished event.
+//
+// Must be called with the lock held.
+func (s *traceEventSink) addCmdEvent(event *Event) {
+	slot, ok := s.cmdSlots[event.CmdID]
+	if !ok {
+		// the command never started
+		return
+	}
+	name := event.Name
+	if name == "" {
+		name = event.Cmd
+	}
+	args := map[string]interface{}{
+		"cmd_id":  event.CmdID,
+		"cmd":     event.Cmd,
+		"attempt": event.Attempt,
+	}
+	if event.ExitCode != nil {
+		args["exit_code"] = *event.ExitCode
+	}
+	if event.Error != "" {
+		args["error"] = event.Error
+	}
+	s.addCompleteEvent(name, "cmd", traceEventSlotsPID, slot, s.cmdStartTimes[event.CmdID], event.Time, args)
+}
+
+// Must be called with the lock held.
+func (s *traceEventSink) addCompleteEvent(name string, category string, pid int, tid int, startTime time.Time, endTime time.Time, args map[string]interface{}) {
+	s.traceEvents = append(s.traceEvents, &traceEvent{
+		Name:      name,
+		Category:  category,
+		Phase:     "X",
+		Timestamp: startTime.Sub(s.startTime).Microseconds(),
+		Duration:  endTime.Sub(startTime).Microseconds(),
+		PID:       pid,
+		TID:       tid,
+		Args:      args,
+	})
+}
+
+// write writes the trace file.
+//
+// Must be called with the lock held.
+func (s *traceEventSink) write() error {
+	traceEvents := []*traceEvent{
+		newTraceEventMetadata("process_name", traceEventSlotsPID, 0, "slots"),
+		newTraceEventMetadata("process_name", traceEventQueuePID, 0, "queue"),
+	}
+	for slot := range s.slots {
+		traceEvents = append(traceEvents, newTraceEventMetadata("thread_name", traceEventSlotsPID, slot, fmt.Sprintf("slot %d", slot)))
+	}
+	sort.SliceStable(s.traceEvents, func(i, j int) bool {
+		return s.traceEvents[i].Timestamp < s.traceEvents[j].Timestamp
+	})
+	data, err := json.Marshal(&traceEventFile{append(traceEvents, s.traceEvents...), "ms"})
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Write(append(data, '\n'))
+	return err
+}
+
+func newTraceEventMetadata(name string, pid int, tid int, value string) *traceEvent {
+	return &traceEvent{
+		Name:  name,
+		Phase: "M",
+		PID:   pid,
+		TID:   tid,
+		Args:  map[string]interface{}{"name": value},
+	}
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pexec
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTraceEventSink(t *testing.T) {
+	startTime := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return startTime.Add(time.Duration(seconds) * time.Second)
+	}
+
+	buffer := &bytes.Buffer{}
+	sink := NewTraceEventSink(buffer)
+	for _, event := range []*Event{
+		newStartedEvent(startTime, 3, 0),
+		newCmdQueuedEvent(at(0), 0, testCmd("foo")),
+		newCmdQueuedEvent(at(0), 1, testCmd("bar")),
+		newCmdQueuedEvent(at(0), 2, testCmd("baz")),
+		newCmdStartedEvent(at(0), 0, testCmd("foo")),
+		newCmdStartedEvent(at(0), 1, testCmd("bar")),
+		newCmdFinishedEvent(at(1), 0, testCmd("foo"), at(0), nil),
+		newCmdStartedEvent(at(1), 2, testCmd("baz")),
+		newCmdFinishedEvent(at(2), 1, testCmd("bar"), at(0), nil),
+		newCmdFinishedEvent(at(4), 2, testCmd("baz"), at(1), nil),
+		newFinishedEvent(at(4), startTime, nil),
+	} {
+		sink.Handle(event)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	traceEventFile := &traceEventFile{}
+	if err := json.Unmarshal(buffer.Bytes(), traceEventFile); err != nil {
+		t.Fatalf("could not json unmarshal: %v", err)
+	}
+	type span struct {
+		Name      string
+		PID       int
+		TID       int
+		Timestamp int64
+		Duration  int64
+	}
+	var spans []span
+	for _, traceEvent := range traceEventFile.TraceEvents {
+		if traceEvent.Phase == "X" {
+			spans = append(spans, span{traceEvent.Name, traceEvent.PID, traceEvent.TID, traceEvent.Timestamp, traceEvent.Duration})
+		}
+	}
+	want := []span{
+		{"queued", traceEventQueuePID, 0, 0, 0},
+		{"queued", traceEventQueuePID, 1, 0, 0},
+		{"foo", traceEventSlotsPID, 0, 0, 1000000},
+		{"queued", traceEventQueuePID, 2, 0, 1000000},
+		{"bar", traceEventSlotsPID, 1, 0, 2000000},
+		{"baz", traceEventSlotsPID, 0, 1000000, 3000000},
+	}
+	if diff := cmp.Diff(want, spans); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+}
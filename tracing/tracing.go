@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package tracing turns the events of a pexec Runner into OpenTelemetry
+// spans.
+//
+// There is a span for the run, and a child span for each attempt of
+// each command. The trace context of a command's span is injected into
+// the environment of the command, as TRACEPARENT by default, if the
+// command is a pexec.EnvCmd.
+package tracing
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+const (
+	instrumentationName = "github.com/zchee/go-pexec/tracing"
+
+	runSpanName = "pexec.run"
+	cmdSpanName = "pexec.cmd"
+)
+
+// Attribute keys of the spans.
+const (
+	NumCmdsKey  = attribute.Key("pexec.num_cmds")
+	CmdIDKey    = attribute.Key("pexec.cmd.id")
+	CmdKey      = attribute.Key("pexec.cmd")
+	CmdNameKey  = attribute.Key("pexec.cmd.name")
+	AttemptKey  = attribute.Key("pexec.cmd.attempt")
+	ReasonKey   = attribute.Key("pexec.cmd.skip_reason")
+	PIDKey      = attribute.Key("process.pid")
+	ExitCodeKey = attribute.Key("process.exit.code")
+	SignalKey   = attribute.Key("process.signal")
+)
+
+// Option is an option for a new Tracer.
+type Option func(*Tracer)
+
+// WithTracerProvider returns an Option that will create spans with the
+// given TracerProvider instead of the global TracerProvider.
+func WithTracerProvider(tracerProvider trace.TracerProvider) Option {
+	return func(tracer *Tracer) {
+		tracer.tracerProvider = tracerProvider
+	}
+}
+
+// WithPropagator returns an Option that will inject the trace context
+// into the environment of the commands with the given propagator
+// instead of the W3C Trace Context propagator.
+//
+// The keys of the propagator are upper-cased to form the names of the
+// environment variables.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(tracer *Tracer) {
+		tracer.propagator = propagator
+	}
+}
+
+// Tracer creates spans from the events of a pexec Runner.
+type Tracer struct {
+	ctx            context.Context
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+	tracer         trace.Tracer
+	runCtx         context.Context
+	runSpan        trace.Span
+	cmdSpans       map[int]trace.Span
+	lock           sync.Mutex
+}
+
+// New returns a new Tracer.
+//
+// The span of the run is a child of the span in ctx if there is one,
+// which should be the context the commands were created with.
+func New(ctx context.Context, options ...Option) *Tracer {
+	tracer := &Tracer{
+		ctx:            ctx,
+		tracerProvider: otel.GetTracerProvider(),
+		propagator:     propagation.TraceContext{},
+		cmdSpans:       make(map[int]trace.Span),
+	}
+	for _, option := range options {
+		option(tracer)
+	}
+	tracer.tracer = tracer.tracerProvider.Tracer(instrumentationName)
+	return tracer
+}
+
+// RunnerOption returns a pexec.RunnerOption that will make the Runner
+// create spans with the Tracer.
+func (t *Tracer) RunnerOption() pexec.RunnerOption {
+	return pexec.WithEventHook(t.Hook)
+}
+
+// Hook creates, updates and ends spans for the event.
+//
+// This is an event hook for pexec.WithEventHook, so the span processor
+// of the TracerProvider must not block when spans end, as with the
+// batch span processor.
+func (t *Tracer) Hook(event *pexec.Event, cmd pexec.Cmd) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	switch event.Type {
+	case pexec.EventTypeStarted:
+		t.runCtx, t.runSpan = t.tracer.Start(
+			t.ctx,
+			runSpanName,
+			trace.WithTimestamp(event.Time),
+			trace.WithAttributes(NumCmdsKey.Int(event.NumCmds)),
+		)
+	case pexec.EventTypeCmdStarted:
+		t.startCmdSpan(event, cmd)
+	case pexec.EventTypeCmdKilled:
+		if span, ok := t.cmdSpans[event.CmdID]; ok {
+			span.AddEvent("killed", trace.WithTimestamp(event.Time))
+		}
+	case pexec.EventTypeCmdRetrying, pexec.EventTypeCmdFinished:
+		t.endCmdSpan(event)
+	case pexec.EventTypeCmdSkipped:
+		if t.runSpan != nil {
+			t.runSpan.AddEvent(
+				"skipped",
+				trace.WithTimestamp(event.Time),
+				trace.WithAttributes(append(cmdAttributes(event), ReasonKey.String(event.Reason))...),
+			)
+		}
+	case pexec.EventTypeFinished:
+		if t.runSpan == nil {
+			return
+		}
+		if event.Error != "" {
+			t.runSpan.SetStatus(codes.Error, event.Error)
+		}
+		t.runSpan.End(trace.WithTimestamp(event.Time))
+		t.runCtx, t.runSpan = nil, nil
+	}
+}
+
+// Must be called with the lock held.
+func (t *Tracer) startCmdSpan(event *pexec.Event, cmd pexec.Cmd) {
+	parentCtx := t.runCtx
+	if parentCtx == nil {
+		parentCtx = t.ctx
+	}
+	spanName := event.Name
+	if spanName == "" {
+		spanName = cmdSpanName
+	}
+	ctx, span := t.tracer.Start(
+		parentCtx,
+		spanName,
+		trace.WithTimestamp(event.Time),
+		trace.WithAttributes(cmdAttributes(event)...),
+	)
+	t.cmdSpans[event.CmdID] = span
+
+	envCmd, ok := cmd.(pexec.EnvCmd)
+	if !ok {
+		return
+	}
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+	env := make([]string, 0, len(carrier))
+	for _, key := range carrier.Keys() {
+		env = append(env, strings.ToUpper(key)+"="+carrier.Get(key))
+	}
+	envCmd.AddEnv(env...)
+}
+
+// Must be called with the lock held.
+func (t *Tracer) endCmdSpan(event *pexec.Event) {
+	span, ok := t.cmdSpans[event.CmdID]
+	if !ok {
+		return
+	}
+	delete(t.cmdSpans, event.CmdID)
+	if event.PID != 0 {
+		span.SetAttributes(PIDKey.Int(event.PID))
+	}
+	if event.ExitCode != nil {
+		span.SetAttributes(ExitCodeKey.Int(*event.ExitCode))
+	}
+	if event.Signal != "" {
+		span.SetAttributes(SignalKey.String(event.Signal))
+	}
+	if event.Error != "" {
+		span.SetStatus(codes.Error, event.Error)
+	}
+	span.End(trace.WithTimestamp(event.Time))
+}
+
+func cmdAttributes(event *pexec.Event) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{
+		CmdIDKey.Int(event.CmdID),
+		CmdKey.String(event.Cmd),
+		AttemptKey.Int(event.Attempt),
+	}
+	if event.Name != "" {
+		attributes = append(attributes, CmdNameKey.String(event.Name))
+	}
+	return attributes
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: Copyright 2021 The go-pexec Authors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	exec "golang.org/x/sys/execabs"
+
+	pexec "github.com/zchee/go-pexec"
+)
+
+func TestTracer(t *testing.T) {
+	spanRecorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder))
+	ctx, parentSpan := tracerProvider.Tracer("test").Start(context.Background(), "parent")
+
+	stdout := &bytes.Buffer{}
+	okCmd := exec.Command("sh", "-c", `echo "$TRACEPARENT"`)
+	okCmd.Stdout = stdout
+	failCmd := exec.Command("sh", "-c", "exit 3")
+
+	tracer := New(ctx, WithTracerProvider(tracerProvider))
+	err := pexec.NewRunner(
+		pexec.WithEventHandler(func(*pexec.Event) {}),
+		tracer.RunnerOption(),
+	).Run([]pexec.Cmd{
+		pexec.NamedExecCmd(ctx, "ok", okCmd),
+		pexec.ExecCmd(ctx, failCmd),
+	})
+	if err == nil {
+		t.Fatal("except err is non-nil")
+	}
+	parentSpan.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spanRecorder.Ended() {
+		spans[span.Name()] = span
+	}
+	runSpan, ok := spans[runSpanName]
+	if !ok {
+		t.Fatalf("no %s span", runSpanName)
+	}
+	if runSpan.Parent().SpanID() != parentSpan.SpanContext().SpanID() {
+		t.Fatal("except run span to be a child of the parent span")
+	}
+	if runSpan.Status().Code != codes.Error {
+		t.Fatalf("except run span status to be error but got %v", runSpan.Status().Code)
+	}
+
+	okSpan, ok := spans["ok"]
+	if !ok {
+		t.Fatal("no ok span")
+	}
+	if okSpan.Parent().SpanID() != runSpan.SpanContext().SpanID() {
+		t.Fatal("except command span to be a child of the run span")
+	}
+	if okSpan.Status().Code == codes.Error {
+		t.Fatal("except ok span status not to be error")
+	}
+	traceParent := strings.TrimSpace(stdout.String())
+	wantTraceParent := "00-" + okSpan.SpanContext().TraceID().String() + "-" + okSpan.SpanContext().SpanID().String() + "-01"
+	if diff := cmp.Diff(wantTraceParent, traceParent); diff != "" {
+		t.Fatalf("(-want +got):\n%s", diff)
+	}
+
+	failSpan, ok := spans[cmdSpanName]
+	if !ok {
+		t.Fatalf("no %s span", cmdSpanName)
+	}
+	if failSpan.Status().Code != codes.Error {
+		t.Fatalf("except failed span status to be error but got %v", failSpan.Status().Code)
+	}
+	var exitCode int64 = -1
+	for _, keyValue := range failSpan.Attributes() {
+		if keyValue.Key == ExitCodeKey {
+			exitCode = keyValue.Value.AsInt64()
+		}
+	}
+	if exitCode != 3 {
+		t.Fatalf("except exit code 3 but got %d", exitCode)
+	}
+}